@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/reconquest/karma-go"
+)
+
+// Approver decides if a mutating tool call may run, the reason of denial is
+// returned to the model as the tool result.
+type Approver interface {
+	Approve(call ToolUse, preview string) (bool, string, error)
+}
+
+// TerminalApprover asks the user about every mutating tool call unless the
+// user allowed the tool for the rest of the session. Tools passed as confirm
+// can't be allowed for the session.
+type TerminalApprover struct {
+	reader *bufio.Reader
+	writer io.Writer
+
+	always  map[string]bool
+	confirm map[string]bool
+	mutex   sync.Mutex
+}
+
+func NewTerminalApprover(
+	reader io.Reader,
+	writer io.Writer,
+	confirm ...string,
+) *TerminalApprover {
+	approver := &TerminalApprover{
+		reader:  bufio.NewReader(reader),
+		writer:  writer,
+		always:  map[string]bool{},
+		confirm: map[string]bool{},
+	}
+
+	for _, name := range confirm {
+		approver.confirm[name] = true
+	}
+
+	return approver
+}
+
+func (approver *TerminalApprover) Approve(call ToolUse, preview string) (bool, string, error) {
+	// tool calls run concurrently, but the user answers one at a time
+	approver.mutex.Lock()
+	defer approver.mutex.Unlock()
+
+	if approver.always[call.Name] {
+		return true, "", nil
+	}
+
+	fmt.Fprintln(approver.writer)
+	fmt.Fprintf(approver.writer, "{%s} %s\n", color.YellowString("approve"), call.Name)
+	fmt.Fprintln(approver.writer, preview)
+
+	for {
+		if approver.confirm[call.Name] {
+			fmt.Fprint(approver.writer, "[y] allow once, [n] deny: ")
+		} else {
+			fmt.Fprintf(
+				approver.writer,
+				"[y] allow once, [a] allow %s for this session, [n] deny: ",
+				call.Name,
+			)
+		}
+
+		answer, err := approver.readLine()
+		if err != nil {
+			return false, "", err
+		}
+
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, "", nil
+
+		case "a", "always":
+			if approver.confirm[call.Name] {
+				continue
+			}
+
+			approver.always[call.Name] = true
+			return true, "", nil
+
+		case "n", "no":
+			fmt.Fprint(approver.writer, "reason (optional): ")
+
+			reason, err := approver.readLine()
+			if err != nil {
+				return false, "", err
+			}
+
+			return false, reason, nil
+		}
+	}
+}
+
+func (approver *TerminalApprover) readLine() (string, error) {
+	line, err := approver.reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", karma.Format(err, "read answer")
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// approve asks the approver about mutating tool calls unless they are
+// allowed by --yes and about tools that need confirmation of every call,
+// other calls are always allowed.
+func (dispatcher *Dispatcher) approve(call ToolUse) error {
+	ask := dispatcher.confirm[call.Name] ||
+		dispatcher.mutating[call.Name] && !dispatcher.yes
+
+	if dispatcher.approver == nil || !ask {
+		return nil
+	}
+
+	allowed, reason, err := dispatcher.approver.Approve(call, dispatcher.previewCall(call))
+	if err != nil {
+		return karma.Format(err, "ask for approval")
+	}
+
+	if allowed {
+		return nil
+	}
+
+	if reason == "" {
+		return fmt.Errorf("the user denied the %s call", call.Name)
+	}
+
+	return fmt.Errorf("the user denied the %s call: %s", call.Name, reason)
+}
+
+// previewCall describes what the call is going to do, writes and patches
+// are shown as diffs.
+func (dispatcher *Dispatcher) previewCall(call ToolUse) string {
+	switch call.Name {
+	case "fs_write":
+		var args WriteFileArguments
+		if json.Unmarshal(call.Input, &args) != nil {
+			break
+		}
+
+		before, err := dispatcher.readWorkspaceFile(args.Path)
+		created := os.IsNotExist(err)
+		if err != nil && !created {
+			return err.Error()
+		}
+
+		after := args.Contents
+		if args.Append {
+			after = string(before) + args.Contents
+		}
+
+		diff := unifiedDiff(args.Path, string(before), after, created, false)
+		if diff == "" {
+			return args.Path + ": no changes"
+		}
+
+		return colorizeDiff(diff)
+
+	case "fs_edit":
+		var args EditFileArguments
+		if json.Unmarshal(call.Input, &args) != nil {
+			break
+		}
+
+		before, err := dispatcher.readWorkspaceFile(args.Path)
+		if err != nil {
+			return err.Error()
+		}
+
+		after, _, err := args.apply(string(before))
+		if err != nil {
+			return err.Error()
+		}
+
+		return colorizeDiff(unifiedDiff(args.Path, string(before), after, false, false))
+
+	case "fs_patch":
+		var args PatchFileArguments
+		if json.Unmarshal(call.Input, &args) != nil {
+			break
+		}
+
+		return colorizeDiff(args.Patch)
+	}
+
+	var input any
+	if json.Unmarshal(call.Input, &input) != nil {
+		return string(call.Input)
+	}
+
+	pretty, err := json.MarshalIndent(input, "", "  ")
+	if err != nil {
+		return string(call.Input)
+	}
+
+	return string(pretty)
+}
+
+func colorizeDiff(diff string) string {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = color.New(color.Bold).Sprint(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = color.GreenString("%s", line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = color.RedString("%s", line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = color.CyanString("%s", line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestTerminalApprover(t *testing.T) {
+	color.NoColor = true
+
+	output := bytes.NewBuffer(nil)
+	approver := NewTerminalApprover(
+		strings.NewReader("maybe\ny\nn\nnot now\na\n"),
+		output,
+	)
+
+	write := ToolUse{Name: "fs_write"}
+	remove := ToolUse{Name: "fs_remove"}
+
+	tests := []struct {
+		call    ToolUse
+		allowed bool
+		reason  string
+	}{
+		{call: write, allowed: true},
+		{call: write, allowed: false, reason: "not now"},
+		{call: remove, allowed: true},
+		// allowed for the session, no input is left
+		{call: remove, allowed: true},
+	}
+
+	for i, test := range tests {
+		allowed, reason, err := approver.Approve(test.call, "preview")
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+
+		if allowed != test.allowed || reason != test.reason {
+			t.Errorf("#%d: expected %v %q, got %v %q", i, test.allowed, test.reason, allowed, reason)
+		}
+	}
+
+	if strings.Count(output.String(), "{approve} ") != 3 {
+		t.Errorf("expected 3 questions, got:\n%s", output)
+	}
+}
+
+func TestTerminalApprover_Confirm(t *testing.T) {
+	color.NoColor = true
+
+	output := bytes.NewBuffer(nil)
+	approver := NewTerminalApprover(
+		strings.NewReader("a\ny\ny\n"),
+		output,
+		"shell_exec",
+	)
+
+	call := ToolUse{Name: "shell_exec"}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := approver.Approve(call, "make")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !allowed {
+			t.Errorf("#%d: expected to be allowed", i)
+		}
+	}
+
+	if strings.Count(output.String(), "{approve} ") != 2 {
+		t.Errorf("expected to be asked every time, got:\n%s", output)
+	}
+
+	if strings.Contains(output.String(), "[a]") {
+		t.Errorf("expected no option to allow for the session, got:\n%s", output)
+	}
+}
+
+type denyApprover struct {
+	previews []string
+}
+
+func (approver *denyApprover) Approve(call ToolUse, preview string) (bool, string, error) {
+	approver.previews = append(approver.previews, preview)
+
+	return false, "wrong file", nil
+}
+
+func TestDispatcher_Approve(t *testing.T) {
+	color.NoColor = true
+
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	approver := &denyApprover{}
+	dispatcher.approver = approver
+
+	err := os.WriteFile(filepath.Join(dispatcher.cwd, "notes.txt"), []byte("hello\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input, _ := json.Marshal(WriteFileArguments{Path: "notes.txt", Contents: "bye\n"})
+
+	err = dispatcher.handleToolCalls([]ToolUse{
+		{ID: "write", Name: "fs_write", Input: input},
+		{ID: "read", Name: "fs_read", Input: []byte(`{"path":"notes.txt"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(approver.previews) != 1 {
+		t.Fatalf("expected only fs_write to be approved, got %d", len(approver.previews))
+	}
+
+	expected := "--- a/notes.txt\n+++ b/notes.txt\n@@ -1 +1 @@\n-hello\n+bye"
+	if approver.previews[0] != expected {
+		t.Errorf("unexpected preview:\n%s", approver.previews[0])
+	}
+
+	for _, content := range dispatcher.thread[0].Content {
+		result := content.ToolResult
+		switch result.ToolUseID {
+		case "write":
+			if !result.IsError || !strings.Contains(result.Content, "denied the fs_write call: wrong file") {
+				t.Errorf("unexpected result of denied call: %#v", result)
+			}
+		case "read":
+			if result.IsError || !strings.Contains(result.Content, `"contents":"1\thello\n"`) {
+				t.Errorf("unexpected result of read call: %#v", result)
+			}
+		}
+	}
+}
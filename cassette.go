@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/reconquest/karma-go"
+)
+
+type CassetteMode int
+
+const (
+	CassetteRecord CassetteMode = iota + 1
+	CassetteReplay
+)
+
+// Cassette is a http.RoundTripper that records every request/response pair
+// into the directory or serves them back from it. Requests are matched by
+// hash of method, path and body, so API tokens and hosts don't matter.
+type Cassette struct {
+	dir       string
+	mode      CassetteMode
+	transport http.RoundTripper
+}
+
+type CassetteEntry struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+type CassetteRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body"`
+}
+
+type CassetteResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+func NewCassette(dir string, mode CassetteMode) (*Cassette, error) {
+	switch mode {
+	case CassetteRecord:
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, karma.Format(err, "create cassette directory: %s", dir)
+		}
+	case CassetteReplay:
+		_, err := os.Stat(dir)
+		if err != nil {
+			return nil, karma.Format(err, "open cassette directory: %s", dir)
+		}
+	}
+
+	return &Cassette{
+		dir:       dir,
+		mode:      mode,
+		transport: http.DefaultTransport,
+	}, nil
+}
+
+func (cassette *Cassette) Client() *http.Client {
+	return &http.Client{Transport: cassette}
+}
+
+func (cassette *Cassette) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body []byte
+	if request.Body != nil {
+		var err error
+		body, err = io.ReadAll(request.Body)
+		if err != nil {
+			return nil, karma.Format(err, "read request body")
+		}
+
+		request.Body.Close()
+		request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	entry := CassetteEntry{
+		Request: CassetteRequest{
+			Method: request.Method,
+			Path:   request.URL.RequestURI(),
+			Body:   string(body),
+		},
+	}
+
+	path := filepath.Join(cassette.dir, entry.Request.hash()+".json")
+
+	if cassette.mode == CassetteReplay {
+		return cassette.replay(request, path)
+	}
+
+	response, err := cassette.transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = &recordingBody{
+		ReadCloser: response.Body,
+		save: func(data []byte) {
+			entry.Response = CassetteResponse{
+				Status: response.StatusCode,
+				Header: response.Header,
+				Body:   string(data),
+			}
+
+			err := writeCassetteEntry(path, entry)
+			if err != nil {
+				log.Println(karma.Format(err, "record cassette: %s", path))
+			}
+		},
+	}
+
+	return response, nil
+}
+
+func (cassette *Cassette) replay(request *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf(
+				"%w: no recorded response for %s %s in %s",
+				ErrNoMoreCompletions,
+				request.Method,
+				request.URL.Path,
+				path,
+			)
+		}
+
+		return nil, karma.Format(err, "read cassette: %s", path)
+	}
+
+	var entry CassetteEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return nil, karma.Format(err, "decode cassette: %s", path)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, http.StatusText(entry.Response.Status)),
+		StatusCode:    entry.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Response.Header,
+		Body:          io.NopCloser(strings.NewReader(entry.Response.Body)),
+		ContentLength: int64(len(entry.Response.Body)),
+		Request:       request,
+	}, nil
+}
+
+func (request CassetteRequest) hash() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s", request.Method, request.Path, request.Body)
+
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+func writeCassetteEntry(path string, entry CassetteEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// recordingBody passes the response body through, so streaming still works,
+// and saves it once it is read completely.
+type recordingBody struct {
+	io.ReadCloser
+
+	buffer bytes.Buffer
+	save   func([]byte)
+	once   sync.Once
+}
+
+func (body *recordingBody) Read(data []byte) (int, error) {
+	n, err := body.ReadCloser.Read(data)
+	body.buffer.Write(data[:n])
+
+	if err == io.EOF {
+		body.once.Do(func() {
+			body.save(body.buffer.Bytes())
+		})
+	}
+
+	return n, err
+}
+
+func (body *recordingBody) Close() error {
+	_, err := io.Copy(&body.buffer, body.ReadCloser)
+	if err == nil {
+		body.once.Do(func() {
+			body.save(body.buffer.Bytes())
+		})
+	}
+
+	return body.ReadCloser.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+func TestCassette_RecordReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			requests++
+
+			writer.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(writer, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"recorded"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":2}}`)
+		},
+	))
+
+	complete := func(mode CassetteMode, text string) (*Completion, error) {
+		cassette, err := NewCassette(dir, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		provider := NewAnthropicProvider(
+			"token",
+			anthropic.WithBaseURL(server.URL),
+			anthropic.WithHTTPClient(cassette.Client()),
+		)
+
+		return provider.Complete(context.Background(), CompletionRequest{
+			Model:     "model",
+			Messages:  []Message{NewTextMessage(RoleUser, text)},
+			MaxTokens: 100,
+		})
+	}
+
+	completion, err := complete(CassetteRecord, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if completion.Content[0].Text != "recorded" {
+		t.Fatalf("unexpected completion: %#v", completion)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 cassette, got %d", len(entries))
+	}
+
+	server.Close()
+
+	completion, err = complete(CassetteReplay, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if completion.Content[0].Text != "recorded" || completion.Usage.OutputTokens != 2 {
+		t.Errorf("unexpected replayed completion: %#v", completion)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected 1 request to the server, got %d", requests)
+	}
+
+	_, err = complete(CassetteReplay, "something else")
+	if !errors.Is(err, ErrNoMoreCompletions) {
+		t.Errorf("expected ErrNoMoreCompletions, got %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/reconquest/karma-go"
+)
+
+const checkpointManifest = "checkpoint.json"
+
+// CheckpointStore keeps files of the workspace as they were before tools
+// changed them, grouped by the assistant turn of the thread, in
+// .aight/checkpoints/<thread>/<turn>.
+type CheckpointStore struct {
+	root string
+	dir  string
+
+	mutex sync.Mutex
+}
+
+type Checkpoint struct {
+	Turn  int              `json:"turn"`
+	Time  time.Time        `json:"time"`
+	Files []CheckpointFile `json:"files"`
+}
+
+// CheckpointFile is a file before the change, a file that didn't exist is
+// removed on restore.
+type CheckpointFile struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	Link    string      `json:"link,omitempty"`
+}
+
+func NewCheckpointStore(cwd string) *CheckpointStore {
+	return &CheckpointStore{
+		root: cwd,
+		dir:  filepath.Join(cwd, stateDir, "checkpoints"),
+	}
+}
+
+func (store *CheckpointStore) threadDir(thread string) (string, error) {
+	if !reThreadName.MatchString(thread) {
+		return "", fmt.Errorf("invalid thread name %q", thread)
+	}
+
+	return filepath.Join(store.dir, thread), nil
+}
+
+// Snapshot saves the files of the workspace unless they are already saved
+// for the turn, directories are saved with all their files.
+func (store *CheckpointStore) Snapshot(thread string, turn int, names []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	dir, err := store.threadDir(thread)
+	if err != nil {
+		return err
+	}
+
+	dir = filepath.Join(dir, strconv.Itoa(turn))
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return karma.Format(err, "create checkpoint directory: %s", dir)
+	}
+
+	checkpoint, err := readCheckpoint(dir)
+	if err != nil {
+		return err
+	}
+
+	if checkpoint == nil {
+		checkpoint = &Checkpoint{Turn: turn, Time: time.Now()}
+	}
+
+	saved := map[string]bool{}
+	for _, file := range checkpoint.Files {
+		saved[file.Path] = true
+	}
+
+	for _, name := range names {
+		err := filepath.WalkDir(
+			filepath.Join(store.root, name),
+			func(path string, entry fs.DirEntry, err error) error {
+				if os.IsNotExist(err) {
+					entry = nil
+				} else if err != nil {
+					return err
+				}
+
+				name, err := filepath.Rel(store.root, path)
+				if err != nil {
+					return err
+				}
+
+				if name == stateDir {
+					return filepath.SkipDir
+				}
+
+				if saved[name] || entry != nil && entry.IsDir() {
+					return nil
+				}
+
+				file, err := store.save(dir, name)
+				if err != nil {
+					return karma.Format(err, "save file: %s", name)
+				}
+
+				saved[name] = true
+
+				checkpoint.Files = append(checkpoint.Files, file)
+
+				return nil
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, checkpointManifest), data, 0644)
+}
+
+func (store *CheckpointStore) save(dir string, name string) (CheckpointFile, error) {
+	path := filepath.Join(store.root, name)
+
+	file := CheckpointFile{Path: name}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+
+	if err != nil {
+		return file, err
+	}
+
+	file.Existed = true
+	file.Mode = info.Mode()
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		file.Link, err = os.Readlink(path)
+
+		return file, err
+	}
+
+	copied := filepath.Join(dir, "files", name)
+
+	err = os.MkdirAll(filepath.Dir(copied), 0755)
+	if err != nil {
+		return file, err
+	}
+
+	return file, copyFile(path, copied)
+}
+
+// Turns returns turns of the thread that have checkpoints in ascending
+// order.
+func (store *CheckpointStore) Turns(thread string) ([]int, error) {
+	dir, err := store.threadDir(thread)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, karma.Format(err, "read checkpoints directory: %s", dir)
+	}
+
+	turns := []int{}
+	for _, entry := range entries {
+		turn, err := strconv.Atoi(entry.Name())
+		if err == nil && entry.IsDir() {
+			turns = append(turns, turn)
+		}
+	}
+
+	sort.Ints(turns)
+
+	return turns, nil
+}
+
+// Restore brings the workspace back to the state before the turn undoing
+// the turn and all later ones, the checkpoints are removed then. It
+// returns paths of restored files.
+func (store *CheckpointStore) Restore(thread string, turn int) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	dir, err := store.threadDir(thread)
+	if err != nil {
+		return nil, err
+	}
+
+	turns, err := store.Turns(thread)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := map[string]bool{}
+
+	// later turns go first, so files end up as they were before the turn
+	for i := len(turns) - 1; i >= 0 && turns[i] >= turn; i-- {
+		checkpointDir := filepath.Join(dir, strconv.Itoa(turns[i]))
+
+		checkpoint, err := readCheckpoint(checkpointDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if checkpoint != nil {
+			for _, file := range checkpoint.Files {
+				err := store.restore(checkpointDir, file)
+				if err != nil {
+					return nil, karma.Format(err, "restore file: %s", file.Path)
+				}
+
+				restored[file.Path] = true
+			}
+		}
+
+		err = os.RemoveAll(checkpointDir)
+		if err != nil {
+			return nil, karma.Format(err, "remove checkpoint: %s", checkpointDir)
+		}
+	}
+
+	paths := []string{}
+	for path := range restored {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func (store *CheckpointStore) restore(dir string, file CheckpointFile) error {
+	path, err := store.checkPath(file.Path)
+	if err != nil {
+		return err
+	}
+
+	// anything but a regular file is replaced, not written through
+	info, err := os.Lstat(path)
+	if err == nil && (!info.Mode().IsRegular() || !file.Existed || file.Link != "") {
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !file.Existed {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	if file.Link != "" {
+		return os.Symlink(file.Link, path)
+	}
+
+	err = copyFile(filepath.Join(dir, "files", file.Path), path)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(path, file.Mode.Perm())
+}
+
+// checkPath returns the path of the file of the manifest in the workspace.
+// The manifest is a file of the workspace too, so its paths are checked
+// like the paths of tools: the file itself is replaced by restore, but its
+// directory must resolve within the workspace.
+func (store *CheckpointStore) checkPath(name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("path must not point outside of the workspace: %s", name)
+	}
+
+	root, err := filepath.EvalSymlinks(store.root)
+	if err != nil {
+		return "", karma.Format(err, "resolve workspace")
+	}
+
+	resolved, err := resolvePath(root, filepath.Dir(name))
+	if err != nil {
+		return "", karma.Format(relativeError(root, err), "resolve path: %s", name)
+	}
+
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || !isLocal(relative) {
+		return "", fmt.Errorf(
+			"path must not point outside of the workspace: %s is in a symlink to outside",
+			name,
+		)
+	}
+
+	return filepath.Join(store.root, name), nil
+}
+
+func readCheckpoint(dir string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointManifest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+	err = json.Unmarshal(data, &checkpoint)
+	if err != nil {
+		return nil, karma.Format(err, "decode checkpoint: %s", dir)
+	}
+
+	return &checkpoint, nil
+}
+
+// checkpoint saves the workspace files before the current turn changes
+// them.
+func (dispatcher *Dispatcher) checkpoint(paths ...string) error {
+	names := []string{}
+	for _, path := range paths {
+		name, err := filepath.Rel(dispatcher.cwd, path)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, name)
+	}
+
+	err := dispatcher.checkpoints.Snapshot(
+		dispatcher.threadName,
+		len(dispatcher.thread),
+		names,
+	)
+	if err != nil {
+		return karma.Format(err, "save checkpoint")
+	}
+
+	return nil
+}
+
+// Undo restores the workspace to the state before the turn, zero means the
+// last turn that changed the workspace.
+func (dispatcher *Dispatcher) Undo(turn int) error {
+	turns, err := dispatcher.checkpoints.Turns(dispatcher.threadName)
+	if err != nil {
+		return err
+	}
+
+	if len(turns) == 0 || turns[len(turns)-1] < turn {
+		return errors.New("there are no changes to undo")
+	}
+
+	if turn == 0 {
+		turn = turns[len(turns)-1]
+	}
+
+	restored, err := dispatcher.checkpoints.Restore(dispatcher.threadName, turn)
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"{%s} restored %d files changed since turn %d: %s",
+		color.YellowString("undo"),
+		len(restored),
+		turn,
+		strings.Join(restored, ", "),
+	)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDispatcher_Undo(t *testing.T) {
+	dispatcher := runScript(t, filepath.Join("testdata", "tools.yaml"), "do it")
+
+	read := func(name string) string {
+		contents, err := os.ReadFile(filepath.Join(dispatcher.cwd, name))
+		if os.IsNotExist(err) {
+			return "<none>"
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(contents)
+	}
+
+	turns, err := dispatcher.checkpoints.Turns(dispatcher.threadName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writes, patch, edit and move with remove
+	if !reflect.DeepEqual(turns, []int{2, 6, 8, 10}) {
+		t.Fatalf("unexpected turns with checkpoints: %v", turns)
+	}
+
+	// /undo is handled without passing it to the model
+	prompt := NewPrompter([]string{"/undo", "next"}, nil)
+
+	err = dispatcher.interact(prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := dispatcher.thread[len(dispatcher.thread)-1]
+	if last.Content[0].Text != "next" {
+		t.Errorf("unexpected last message: %#v", last)
+	}
+
+	expected := map[string]string{
+		"notes.txt":   "hello, world\n",
+		"renamed.txt": "<none>",
+		"src/main.go": "package main\n\n// main does nothing.\nfunc main() {}\n\nfunc init() { main() }\n",
+	}
+	for name, contents := range expected {
+		if read(name) != contents {
+			t.Errorf("%s: expected %q after undo of the last turn, got %q", name, contents, read(name))
+		}
+	}
+
+	err = dispatcher.Undo(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"notes.txt", "renamed.txt", "src/main.go"} {
+		if read(name) != "<none>" {
+			t.Errorf("%s: expected to not exist after undo of all turns, got %q", name, read(name))
+		}
+	}
+
+	err = dispatcher.Undo(0)
+	if err == nil {
+		t.Error("expected error when there is nothing to undo")
+	}
+}
+
+func TestCheckpointStore_Symlink(t *testing.T) {
+	root := t.TempDir()
+	store := NewCheckpointStore(root)
+
+	err := os.Symlink("target", filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Snapshot(defaultThreadName, 1, []string{"link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(root, "link"), []byte("file\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := store.Restore(defaultThreadName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(restored, []string{"link"}) {
+		t.Errorf("unexpected restored files: %v", restored)
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "link"))
+	if err != nil || target != "target" {
+		t.Errorf("expected symlink to be restored, got %q %v", target, err)
+	}
+}
+
+func TestCheckpointStore_RestoreOutside(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "workspace")
+	store := NewCheckpointStore(root)
+
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(parent, "outside.txt"), []byte("outside\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(parent, filepath.Join(root, "up"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../outside.txt", "up/outside.txt"} {
+		dir := filepath.Join(store.dir, defaultThreadName, "1")
+
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(
+			filepath.Join(dir, checkpointManifest),
+			[]byte(`{"turn": 1, "files": [{"path": "`+name+`", "existed": false}]}`),
+			0644,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.Restore(defaultThreadName, 1)
+		if err == nil || !strings.Contains(err.Error(), "outside of the workspace") {
+			t.Errorf("%s: expected path outside of the workspace to be rejected, got %v", name, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(parent, "outside.txt")); err != nil {
+			t.Fatalf("%s: file outside of the workspace is removed: %v", name, err)
+		}
+	}
+}
+
+func TestDispatcher_WriteStateDir(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	manifest := filepath.Join(stateDir, "checkpoints", defaultThreadName, "1", checkpointManifest)
+
+	_, err := dispatcher.writeFile(WriteFileArguments{Path: manifest, Contents: "{}"})
+	if err == nil || !strings.Contains(err.Error(), stateDir+" directory") {
+		t.Errorf("expected writes to %s to be rejected, got %v", stateDir, err)
+	}
+
+	err = os.Symlink(stateDir, filepath.Join(dispatcher.cwd, "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dispatcher.writeFile(WriteFileArguments{Path: "state/config.yaml", Contents: "{}"})
+	if err == nil || !strings.Contains(err.Error(), stateDir+" directory") {
+		t.Errorf("expected writes through symlinks to %s to be rejected, got %v", stateDir, err)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	defaultCompactThreshold = 150000
+
+	compactPrompt = `You are compacting a conversation between a user and an AI assistant ` +
+		`that works in a sandboxed workspace using tools. Summarize the transcript ` +
+		`below so the assistant can continue the work without it: the user's goals ` +
+		`and instructions, decisions made, files and data that were inspected or ` +
+		`changed and their relevant contents, and what remains to be done. ` +
+		`Reply with the summary only.`
+)
+
+// estimateTokens roughly estimates the number of tokens of the message,
+// it is about four bytes per token for English text and JSON.
+func estimateTokens(message Message) int {
+	size := 0
+	for _, content := range message.Content {
+		size += len(content.Text)
+
+		if content.ToolUse != nil {
+			size += len(content.ToolUse.Name) + len(content.ToolUse.Input)
+		}
+
+		if content.ToolResult != nil {
+			size += len(content.ToolResult.Content)
+		}
+	}
+
+	return size/4 + 1
+}
+
+func estimateThreadTokens(thread []Message) int {
+	tokens := 0
+	for _, message := range thread {
+		tokens += estimateTokens(message)
+	}
+
+	return tokens
+}
+
+// findCompactionCut returns index of the first message to keep as is. The
+// kept part starts either with a user text message or with the assistant
+// message after a complete round of tool calls and their results, so tool
+// calls are never separated from their results and a long tool loop after
+// a single prompt can be compacted too.
+func findCompactionCut(thread []Message, keep int) int {
+	cut := -1
+	tokens := 0
+	for i := len(thread) - 1; i > 0; i-- {
+		tokens += estimateTokens(thread[i])
+
+		if !isPrompt(thread[i]) && !isAfterToolRound(thread, i) {
+			continue
+		}
+
+		if cut > 0 && tokens > keep {
+			break
+		}
+
+		cut = i
+	}
+
+	return cut
+}
+
+func isPrompt(message Message) bool {
+	if message.Role != RoleUser || len(message.Content) == 0 {
+		return false
+	}
+
+	for _, content := range message.Content {
+		if content.Type != ContentTypeText {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isAfterToolRound reports whether the message follows the results of all
+// tool calls of the previous assistant message.
+func isAfterToolRound(thread []Message, i int) bool {
+	if i < 2 || thread[i].Role != RoleAssistant {
+		return false
+	}
+
+	calls, results := thread[i-2], thread[i-1]
+	if calls.Role != RoleAssistant || results.Role != RoleUser {
+		return false
+	}
+
+	open := map[string]bool{}
+	for _, content := range calls.Content {
+		if content.ToolUse != nil {
+			open[content.ToolUse.ID] = true
+		}
+	}
+
+	for _, content := range results.Content {
+		if content.ToolResult != nil {
+			delete(open, content.ToolResult.ToolUseID)
+		}
+	}
+
+	return len(open) == 0 && len(results.Content) > 0 && !isPrompt(results)
+}
+
+// compact replaces older turns of the thread with their summary once the
+// thread grows over the threshold. The full thread is archived before.
+func (dispatcher *Dispatcher) compact() error {
+	if dispatcher.compactThreshold <= 0 {
+		return nil
+	}
+
+	dispatcher.mutex.Lock()
+	thread := append([]Message{}, dispatcher.thread...)
+	dispatcher.mutex.Unlock()
+
+	tokens := estimateThreadTokens(thread)
+	if tokens <= dispatcher.compactThreshold {
+		return nil
+	}
+
+	cut := findCompactionCut(thread, dispatcher.compactThreshold/4)
+	if cut <= 0 {
+		return errors.New("no turn boundary to compact the thread at")
+	}
+
+	summary, err := dispatcher.summarize(thread[:cut])
+	if err != nil {
+		return karma.Format(err, "summarize thread")
+	}
+
+	err = dispatcher.archiveThread(thread)
+	if err != nil {
+		return karma.Format(err, "archive thread")
+	}
+
+	summary = "Summary of the earlier conversation:\n\n" + summary
+
+	// the thread must start with a user message, so the summary is put
+	// before the prompt or becomes a prompt itself if the cut is in the
+	// middle of a tool loop
+	var compacted []Message
+	if isPrompt(thread[cut]) {
+		first := thread[cut]
+		first.Content = append([]Content{NewTextContent(summary)}, first.Content...)
+
+		compacted = append([]Message{first}, thread[cut+1:]...)
+	} else {
+		compacted = append([]Message{NewTextMessage(RoleUser, summary)}, thread[cut:]...)
+	}
+
+	dispatcher.mutex.Lock()
+	// messages could not be added while summarizing, Communicate is the only
+	// writer, but keep whatever was appended just in case
+	compacted = append(compacted, dispatcher.thread[len(thread):]...)
+	dispatcher.thread = compacted
+	err = dispatcher.saveThread()
+	dispatcher.mutex.Unlock()
+	if err != nil {
+		return karma.Format(err, "save thread")
+	}
+
+	log.Printf(
+		"{%s} compacted %d messages (~%d tokens) into a summary, ~%d tokens left",
+		color.YellowString("aight"),
+		cut,
+		tokens,
+		estimateThreadTokens(compacted),
+	)
+
+	return nil
+}
+
+// summarize summarizes the transcript of the thread in chunks, so the
+// summarization request can't outgrow the context window itself. Every
+// chunk is summarized together with the summary of the previous ones.
+func (dispatcher *Dispatcher) summarize(thread []Message) (string, error) {
+	transcript := bytes.NewBuffer(nil)
+	printThread(transcript, thread)
+
+	// about four bytes per token, half of the threshold is left for the
+	// summary of the previous chunks and the reply
+	chunks := chunkTranscript(transcript.String(), dispatcher.compactThreshold*4/2)
+
+	summary := ""
+	for i, chunk := range chunks {
+		text := chunk
+		if summary != "" {
+			text = fmt.Sprintf(
+				"Summary of the earlier part of the transcript:\n\n%s\n\n"+
+					"The transcript continues (part %d of %d):\n\n%s",
+				summary,
+				i+1,
+				len(chunks),
+				chunk,
+			)
+		}
+
+		var err error
+		summary, err = dispatcher.summarizeChunk(text)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return summary, nil
+}
+
+func (dispatcher *Dispatcher) summarizeChunk(text string) (string, error) {
+	requestRateLimit.Take()
+
+	completion, err := dispatcher.provider.Complete(
+		context.Background(),
+		CompletionRequest{
+			Model:  dispatcher.baseModel,
+			System: compactPrompt,
+			Messages: []Message{
+				NewTextMessage(RoleUser, text),
+			},
+			MaxTokens: dispatcher.maxTokens,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	texts := []string{}
+	for _, content := range completion.Content {
+		if content.Type == ContentTypeText {
+			texts = append(texts, content.Text)
+		}
+	}
+
+	summary := strings.TrimSpace(strings.Join(texts, "\n"))
+	if summary == "" {
+		return "", errors.New("summary is empty")
+	}
+
+	return summary, nil
+}
+
+// chunkTranscript splits the transcript at line boundaries into chunks of
+// at most size bytes, longer lines are split as well.
+func chunkTranscript(transcript string, size int) []string {
+	if size <= 0 || len(transcript) <= size {
+		return []string{transcript}
+	}
+
+	chunks := []string{}
+	chunk := strings.Builder{}
+	for _, line := range strings.SplitAfter(transcript, "\n") {
+		for len(line) > 0 {
+			if chunk.Len() > 0 && chunk.Len()+len(line) > size {
+				chunks = append(chunks, chunk.String())
+				chunk.Reset()
+			}
+
+			part := line
+			if len(part) > size {
+				// don't split multibyte characters
+				end := size
+				for end > 1 && !utf8.RuneStart(part[end]) {
+					end--
+				}
+
+				part = part[:end]
+			}
+
+			chunk.WriteString(part)
+			line = line[len(part):]
+		}
+	}
+
+	if chunk.Len() > 0 {
+		chunks = append(chunks, chunk.String())
+	}
+
+	return chunks
+}
+
+// archiveThread keeps the full thread in .aight/threads/<name>.archive
+func (dispatcher *Dispatcher) archiveThread(thread []Message) error {
+	dir := filepath.Join(
+		dispatcher.threads.dir,
+		dispatcher.threadName+".archive",
+	)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(thread, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(
+		dir,
+		fmt.Sprintf("%s.json", time.Now().Format("20060102-150405.000")),
+	)
+
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// summaryProvider replies to every summarization request with the given
+// summary and records the requests.
+type summaryProvider struct {
+	summary  string
+	requests []CompletionRequest
+}
+
+func (provider *summaryProvider) Complete(
+	ctx context.Context,
+	request CompletionRequest,
+) (*Completion, error) {
+	provider.requests = append(provider.requests, request)
+
+	return &Completion{
+		Content: []Content{NewTextContent(provider.summary)},
+	}, nil
+}
+
+func TestDispatcher_Compact(t *testing.T) {
+	provider := &summaryProvider{
+		summary: "The user asked to create notes.txt, it was created.",
+	}
+
+	dispatcher := NewDispatcher(t.TempDir(), "script", false, provider)
+	dispatcher.compactThreshold = 100
+
+	long := strings.Repeat("lorem ipsum ", 50)
+
+	dispatcher.thread = []Message{
+		NewTextMessage(RoleUser, "create notes.txt "+long),
+		{
+			Role: RoleAssistant,
+			Content: []Content{
+				NewToolUseContent("call_1", "fs_write", []byte(`{"path":"notes.txt"}`)),
+			},
+		},
+		{
+			Role:    RoleUser,
+			Content: []Content{NewToolResultContent("call_1", "true", false)},
+		},
+		NewTextMessage(RoleAssistant, "Created. "+long),
+		NewTextMessage(RoleUser, "now read it"),
+		{
+			Role: RoleAssistant,
+			Content: []Content{
+				NewToolUseContent("call_2", "fs_read", []byte(`{"path":"notes.txt"}`)),
+			},
+		},
+		{
+			Role:    RoleUser,
+			Content: []Content{NewToolResultContent("call_2", `""`, false)},
+		},
+	}
+
+	err := dispatcher.compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dispatcher.thread) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(dispatcher.thread))
+	}
+
+	first := dispatcher.thread[0]
+	if first.Role != RoleUser || len(first.Content) != 2 {
+		t.Fatalf("unexpected first message: %#v", first)
+	}
+
+	if !strings.Contains(first.Content[0].Text, "notes.txt, it was created") {
+		t.Errorf("expected summary, got %q", first.Content[0].Text)
+	}
+
+	if first.Content[1].Text != "now read it" {
+		t.Errorf("expected original prompt, got %q", first.Content[1].Text)
+	}
+
+	if dispatcher.thread[2].Content[0].ToolResult.ToolUseID != "call_2" {
+		t.Errorf("expected open tool call to be kept: %#v", dispatcher.thread[2])
+	}
+
+	archives, err := os.ReadDir(filepath.Join(dispatcher.threads.dir, "default.archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archives) != 1 {
+		t.Errorf("expected 1 archive, got %d", len(archives))
+	}
+
+	saved, err := dispatcher.threads.Read(defaultThreadName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saved) != 3 {
+		t.Errorf("expected compacted thread to be saved, got %d messages", len(saved))
+	}
+}
+
+func TestDispatcher_CompactToolLoop(t *testing.T) {
+	provider := &summaryProvider{summary: "Read files 1 to 7."}
+
+	dispatcher := NewDispatcher(t.TempDir(), "script", false, provider)
+	dispatcher.compactThreshold = 400
+
+	dispatcher.thread = []Message{NewTextMessage(RoleUser, "read all files")}
+	for i := 1; i <= 10; i++ {
+		id := fmt.Sprintf("call_%d", i)
+
+		dispatcher.thread = append(
+			dispatcher.thread,
+			Message{
+				Role: RoleAssistant,
+				Content: []Content{
+					NewToolUseContent(id, "fs_read", []byte(`{"path":"file.txt"}`)),
+				},
+			},
+			Message{
+				Role: RoleUser,
+				Content: []Content{
+					NewToolResultContent(id, strings.Repeat("data ", 100), false),
+				},
+			},
+		)
+	}
+
+	err := dispatcher.compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thread := dispatcher.thread
+	if len(thread) >= 21 || len(thread)%2 != 1 {
+		t.Fatalf("unexpected number of messages: %d", len(thread))
+	}
+
+	if !isPrompt(thread[0]) || !strings.Contains(thread[0].Content[0].Text, "Read files 1 to 7.") {
+		t.Fatalf("expected summary prompt, got %#v", thread[0])
+	}
+
+	// every kept tool call is followed by its result
+	for i := 1; i < len(thread); i += 2 {
+		use, result := thread[i].Content[0].ToolUse, thread[i+1].Content[0].ToolResult
+		if use == nil || result == nil || use.ID != result.ToolUseID {
+			t.Errorf("message %d: tool call is separated from its result", i)
+		}
+	}
+
+	if estimateThreadTokens(thread) > dispatcher.compactThreshold {
+		t.Errorf("thread is not compacted: ~%d tokens", estimateThreadTokens(thread))
+	}
+
+	// the transcript is summarized in chunks that fit the threshold
+	if len(provider.requests) < 2 {
+		t.Errorf("expected the transcript to be chunked, got %d requests", len(provider.requests))
+	}
+
+	for _, request := range provider.requests {
+		if tokens := estimateThreadTokens(request.Messages); tokens > dispatcher.compactThreshold {
+			t.Errorf("summarization request is too large: ~%d tokens", tokens)
+		}
+	}
+
+	if !strings.Contains(provider.requests[1].Messages[0].Content[0].Text, "Read files 1 to 7.") {
+		t.Errorf("expected the previous summary to be passed on")
+	}
+}
+
+func TestChunkTranscript(t *testing.T) {
+	chunks := chunkTranscript("ab\ncdefg\nh\n", 4)
+	if strings.Join(chunks, "|") != "ab\n|cdef|g\nh\n" {
+		t.Errorf("unexpected chunks: %q", chunks)
+	}
+
+	chunks = chunkTranscript("ééé", 3)
+	if strings.Join(chunks, "|") != "é|é|é" {
+		t.Errorf("unexpected chunks: %q", chunks)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/reconquest/karma-go"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultProfileName = "default"
+	defaultMaxTokens   = 2000
+	defaultRateLimit   = 5
+)
+
+// Config is read from the user-level config and then from the workspace
+// config, values of the workspace override values of the user. The
+// workspace comes from the repository the tool runs in, so it can't set
+// the endpoint, the token, the shell environment and the python
+// interpreter, and its rules are added to the rules of the user instead of
+// replacing them.
+//
+//	profile: local
+//	profiles:
+//	  local:
+//	    provider: openai
+//	    base_url: http://localhost:8080/v1
+//	    model: qwen2.5-coder
+//	    max_tokens: 4000
+//	    temperature: 0.2
+//	    tools: [fs_list, fs_tree, fs_read]
+//	    rate_limit: 1
+//	    compact: 30000
+//	    system: prompts/local.md
+//	    rules:
+//	      - {action: deny, tool: "*", paths: ["*.env", ".git/**"]}
+//	      - {action: allow, tool: fs_write, paths: ["src/**"]}
+//	    shell: {timeout: 300, confirm: true}
+type Config struct {
+	Profile  string             `yaml:"profile"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+type Profile struct {
+	Provider    string   `yaml:"provider"`
+	Model       string   `yaml:"model"`
+	BaseURL     string   `yaml:"base_url"`
+	Token       string   `yaml:"token"`
+	MaxTokens   int      `yaml:"max_tokens"`
+	Temperature *float64 `yaml:"temperature"`
+	Tools       []string `yaml:"tools"`
+	RateLimit   int      `yaml:"rate_limit"`
+	System      string   `yaml:"system"`
+
+	// Compact is the compaction threshold in tokens, zero disables it
+	Compact *int `yaml:"compact"`
+
+	Rules []PolicyRule `yaml:"rules"`
+
+	Shell  ShellOptions  `yaml:"shell"`
+	Python PythonOptions `yaml:"python"`
+}
+
+// defaultProfile has no model, it depends on the provider that can be set
+// by the flags after the profile is read, see withDefaults.
+var defaultProfile = Profile{
+	Provider:  "anthropic",
+	MaxTokens: defaultMaxTokens,
+	RateLimit: defaultRateLimit,
+	Shell:     defaultShellOptions,
+	Python:    defaultPythonOptions,
+}
+
+// configPaths returns paths of the user-level and the workspace configs.
+func configPaths(cwd string) ([]string, []string) {
+	paths := []string{}
+
+	dir, err := os.UserConfigDir()
+	if err == nil {
+		paths = append(paths, filepath.Join(dir, "aight", "config.yaml"))
+	}
+
+	return paths, []string{filepath.Join(cwd, stateDir, "config.yaml")}
+}
+
+// LoadConfig reads and merges the user-level and then the workspace configs
+// by given paths, missing files are skipped.
+func LoadConfig(user []string, workspace []string) (*Config, error) {
+	config := &Config{
+		Profiles: map[string]Profile{},
+	}
+
+	for i, path := range append(user, workspace...) {
+		trusted := i < len(user)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, karma.Format(err, "read config: %s", path)
+		}
+
+		var file Config
+		err = yaml.Unmarshal(data, &file)
+		if err != nil {
+			return nil, karma.Format(err, "decode config: %s", path)
+		}
+
+		if file.Profile != "" {
+			config.Profile = file.Profile
+		}
+
+		for name, profile := range file.Profiles {
+			// system prompt is relative to the config it's specified in
+			if profile.System != "" && !filepath.IsAbs(profile.System) {
+				profile.System = filepath.Join(filepath.Dir(path), profile.System)
+			}
+
+			if !trusted {
+				var ignored []string
+				profile, ignored = profile.untrusted(config.Profiles[name])
+				if len(ignored) > 0 {
+					log.Printf(
+						"{%s} %s: profile %s: ignored %s, they are accepted only from the user config and flags",
+						color.YellowString("config"),
+						path,
+						name,
+						strings.Join(ignored, ", "),
+					)
+				}
+			}
+
+			config.Profiles[name] = config.Profiles[name].merge(profile)
+		}
+	}
+
+	return config, nil
+}
+
+// GetProfile returns the profile by name merged over the defaults, empty
+// name means the profile selected by the config.
+func (config *Config) GetProfile(name string) (Profile, error) {
+	if name == "" {
+		name = config.Profile
+	}
+
+	if name == "" {
+		name = defaultProfileName
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok && name != defaultProfileName {
+		return Profile{}, fmt.Errorf("profile %q is not found in config", name)
+	}
+
+	return defaultProfile.merge(profile), nil
+}
+
+// defaultModels are used if neither the profile nor the flags set a model.
+var defaultModels = map[string]string{
+	"anthropic": defaultModel,
+	"openai":    defaultOpenAIModel,
+}
+
+// withDefaults returns the profile with the defaults that depend on other
+// values, it is called once the flags are merged.
+func (profile Profile) withDefaults() Profile {
+	if profile.Model == "" {
+		profile.Model = defaultModels[profile.Provider]
+	}
+
+	if profile.Compact == nil {
+		compact := defaultCompactThreshold
+		profile.Compact = &compact
+	}
+
+	return profile
+}
+
+// untrusted returns the profile of the workspace config without values
+// that would send the token elsewhere or run commands outside of the
+// sandbox, and with its rules added to the rules of the base profile. It
+// returns keys of the ignored values.
+func (profile Profile) untrusted(base Profile) (Profile, []string) {
+	ignored := []string{}
+
+	if profile.BaseURL != "" {
+		profile.BaseURL = ""
+		ignored = append(ignored, "base_url")
+	}
+
+	if profile.Token != "" {
+		profile.Token = ""
+		ignored = append(ignored, "token")
+	}
+
+	if profile.Shell.Env != nil {
+		profile.Shell.Env = nil
+		ignored = append(ignored, "shell.env")
+	}
+
+	if profile.Python.Interpreter != "" {
+		profile.Python.Interpreter = ""
+		ignored = append(ignored, "python.interpreter")
+	}
+
+	// deny rules always win and allow rules restrict tools that had no
+	// allow rules, unless the user has allow rules they could widen
+	if profile.Rules != nil {
+		rules := append([]PolicyRule{}, base.Rules...)
+
+		widening := false
+		for _, rule := range base.Rules {
+			widening = widening || rule.Action == PolicyAllow
+		}
+
+		for _, rule := range profile.Rules {
+			if widening && rule.Action == PolicyAllow {
+				ignored = append(ignored, "allow rule for "+rule.Tool)
+				continue
+			}
+
+			rules = append(rules, rule)
+		}
+
+		profile.Rules = rules
+	}
+
+	return profile, ignored
+}
+
+// merge returns the profile with values of other profile that are set.
+func (profile Profile) merge(other Profile) Profile {
+	if other.Provider != "" {
+		profile.Provider = other.Provider
+	}
+
+	if other.Model != "" {
+		profile.Model = other.Model
+	}
+
+	if other.BaseURL != "" {
+		profile.BaseURL = other.BaseURL
+	}
+
+	if other.Token != "" {
+		profile.Token = other.Token
+	}
+
+	if other.MaxTokens != 0 {
+		profile.MaxTokens = other.MaxTokens
+	}
+
+	if other.Temperature != nil {
+		profile.Temperature = other.Temperature
+	}
+
+	if other.Tools != nil {
+		profile.Tools = other.Tools
+	}
+
+	if other.RateLimit != 0 {
+		profile.RateLimit = other.RateLimit
+	}
+
+	if other.System != "" {
+		profile.System = other.System
+	}
+
+	if other.Compact != nil {
+		profile.Compact = other.Compact
+	}
+
+	if other.Rules != nil {
+		profile.Rules = other.Rules
+	}
+
+	profile.Shell = profile.Shell.merge(other.Shell)
+	profile.Python = profile.Python.merge(other.Python)
+
+	return profile
+}
@@ -5,12 +5,11 @@ import (
 	"log"
 
 	"github.com/fatih/color"
-	"github.com/liushuangls/go-anthropic/v2"
 	"github.com/reconquest/karma-go"
 )
 
-func callTool[T any](fn func(T) (any, error)) ToolCallFunc {
-	return func(call anthropic.MessageContentToolUse) (any, error) {
+func callTool[T any](dispatcher *Dispatcher, fn func(T) (any, error)) ToolCallFunc {
+	return func(call ToolUse) (any, error) {
 		var value T
 		err := json.Unmarshal([]byte(call.Input), &value)
 		if err != nil {
@@ -21,6 +20,21 @@ func callTool[T any](fn func(T) (any, error)) ToolCallFunc {
 
 		log.Printf("{%s} %s: %+v", role, call.Name, value)
 
+		var paths []string
+		if arguments, ok := any(value).(PathArguments); ok {
+			paths = arguments.Paths()
+		}
+
+		err = dispatcher.policy.Check(call.Name, paths)
+		if err != nil {
+			return nil, err
+		}
+
+		err = dispatcher.approve(call)
+		if err != nil {
+			return nil, err
+		}
+
 		return fn(value)
 	}
 }
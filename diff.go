@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext = 3
+
+	// diffMaxCells limits memory of the LCS table, bigger changes are shown
+	// as removal of all old lines and addition of all new ones
+	diffMaxCells = 4 << 20
+)
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+
+	// old and new are zero-based line numbers the op is at
+	old int
+	new int
+}
+
+// unifiedDiff returns a unified diff of two texts, empty if they are equal.
+// Created and deleted files are diffed against /dev/null.
+func unifiedDiff(
+	path string,
+	before string,
+	after string,
+	created bool,
+	deleted bool,
+) string {
+	if before == after {
+		return ""
+	}
+
+	from, to := "a/"+path, "b/"+path
+	if created {
+		from = "/dev/null"
+	}
+
+	if deleted {
+		to = "/dev/null"
+	}
+
+	var buffer strings.Builder
+
+	fmt.Fprintf(&buffer, "--- %s\n+++ %s\n", from, to)
+
+	for _, hunk := range diffHunks(diffLines(splitLines(before), splitLines(after))) {
+		buffer.WriteString(hunk)
+	}
+
+	return buffer.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func diffLines(a []string, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []diffOp{}
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{kind: ' ', line: a[i], old: i, new: i})
+	}
+
+	middleA := a[prefix : len(a)-suffix]
+	middleB := b[prefix : len(b)-suffix]
+
+	for _, op := range diffMiddle(middleA, middleB) {
+		op.old += prefix
+		op.new += prefix
+		ops = append(ops, op)
+	}
+
+	for i := 0; i < suffix; i++ {
+		ops = append(ops, diffOp{
+			kind: ' ',
+			line: a[len(a)-suffix+i],
+			old:  len(a) - suffix + i,
+			new:  len(b) - suffix + i,
+		})
+	}
+
+	return ops
+}
+
+func diffMiddle(a []string, b []string) []diffOp {
+	ops := []diffOp{}
+
+	if (len(a)+1)*(len(b)+1) > diffMaxCells {
+		for i, line := range a {
+			ops = append(ops, diffOp{kind: '-', line: line, old: i, new: 0})
+		}
+
+		for j, line := range b {
+			ops = append(ops, diffOp{kind: '+', line: line, old: len(a), new: j})
+		}
+
+		return ops
+	}
+
+	// lcs[i][j] is length of the longest common subsequence of a[i:] and b[j:]
+	width := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else {
+				lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', line: a[i], old: i, new: j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[(i+1)*width+j] >= lcs[i*width+j+1]):
+			ops = append(ops, diffOp{kind: '-', line: a[i], old: i, new: j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: b[j], old: i, new: j})
+			j++
+		}
+	}
+
+	return ops
+}
+
+func diffHunks(ops []diffOp) []string {
+	hunks := []string{}
+
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+
+		// extend the hunk while the next change is close enough to share
+		// the context
+		end := start
+		for next := start; next < len(ops); next++ {
+			if ops[next].kind == ' ' {
+				continue
+			}
+
+			if next-end > 2*diffContext {
+				break
+			}
+
+			end = next
+		}
+
+		from := max(0, start-diffContext)
+		to := min(len(ops), end+diffContext+1)
+
+		hunks = append(hunks, formatHunk(ops[from:to]))
+
+		start = to
+	}
+
+	return hunks
+}
+
+func formatHunk(ops []diffOp) string {
+	var body strings.Builder
+
+	oldCount, newCount := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case ' ':
+			oldCount++
+			newCount++
+		case '-':
+			oldCount++
+		case '+':
+			newCount++
+		}
+
+		body.WriteByte(op.kind)
+		body.WriteString(op.line)
+
+		if !strings.HasSuffix(op.line, "\n") {
+			body.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+
+	oldStart := ops[0].old + 1
+	if oldCount == 0 {
+		oldStart--
+	}
+
+	newStart := ops[0].new + 1
+	if newCount == 0 {
+		newStart--
+	}
+
+	return fmt.Sprintf(
+		"@@ -%s +%s @@\n%s",
+		hunkRange(oldStart, oldCount),
+		hunkRange(newStart, newCount),
+		body.String(),
+	)
+}
+
+func hunkRange(start int, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		before   string
+		after    string
+		created  bool
+		deleted  bool
+		expected string
+	}{
+		{
+			before:   "a\nb\nc\n",
+			after:    "a\nb\nc\n",
+			expected: "",
+		},
+		{
+			before:   "",
+			after:    "a\nb\n",
+			created:  true,
+			expected: "--- /dev/null\n+++ b/file\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			before:   "a\nb\n",
+			after:    "",
+			deleted:  true,
+			expected: "--- a/file\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			before:   "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			after:    "1\ntwo\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			expected: "--- a/file\n+++ b/file\n@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n@@ -9,4 +9,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+		{
+			before:   "a\nb",
+			after:    "a\nc",
+			expected: "--- a/file\n+++ b/file\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	}
+
+	for i, test := range tests {
+		actual := unifiedDiff("file", test.before, test.after, test.created, test.deleted)
+		if actual != test.expected {
+			t.Errorf("#%d: unexpected diff:\n%s\nexpected:\n%s", i, actual, test.expected)
+		}
+	}
+}
@@ -6,31 +6,62 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/invopop/jsonschema"
-	"github.com/liushuangls/go-anthropic/v2"
 	"github.com/reconquest/karma-go"
 )
 
-type ToolCallFunc func(anthropic.MessageContentToolUse) (any, error)
+type ToolCallFunc func(ToolUse) (any, error)
 
 type Dispatcher struct {
-	client *anthropic.Client
+	provider Provider
 
 	baseModel string
+	maxTokens int
+	system    string
 
-	thread []anthropic.Message
-	tools  []anthropic.ToolDefinition
+	temperature *float64
+
+	// compactThreshold is the estimated number of tokens of the thread
+	// after which older turns are summarized, zero disables compaction
+	compactThreshold int
+
+	threads    *ThreadStore
+	threadName string
+
+	checkpoints *CheckpointStore
+
+	thread []Message
+	tools  []ToolDefinition
 	funcs  map[string]ToolCallFunc
 
+	// mutating tools change the workspace and need approval unless yes is
+	// set, confirm tools need approval of every call
+	mutating map[string]bool
+	confirm  map[string]bool
+	yes      bool
+	approver Approver
+	policy   *Policy
+
+	// overlay simulates changes of the workspace in dry-run mode, nil
+	// means changes are made for real
+	overlay *Overlay
+
+	// autoCommit commits changes of every turn to the aight/<thread> branch
+	// of the workspace repository
+	autoCommit bool
+
 	mutex sync.Mutex
 
+	// stream prints completions as they arrive, nil disables streaming
+	stream StreamHandler
+
+	shell         ShellOptions
+	pythonOptions PythonOptions
+
 	cwd     string
 	verbose bool
 }
@@ -39,69 +70,55 @@ func NewDispatcher(
 	cwd string,
 	model string,
 	verbose bool,
-	token string,
+	provider Provider,
 ) *Dispatcher {
-	client := anthropic.NewClient(token)
-
-	thread := []anthropic.Message{}
+	thread := []Message{}
 
 	dispatcher := &Dispatcher{
 		cwd:       cwd,
 		baseModel: model,
+		maxTokens: defaultMaxTokens,
+
+		compactThreshold: defaultCompactThreshold,
 
-		client: client,
-		thread: thread,
-		mutex:  sync.Mutex{},
+		provider:   provider,
+		threads:    NewThreadStore(cwd),
+		threadName: defaultThreadName,
+		thread:     thread,
+		mutex:      sync.Mutex{},
 
-		tools:   []anthropic.ToolDefinition{},
-		funcs:   map[string]ToolCallFunc{},
-		verbose: verbose,
+		checkpoints: NewCheckpointStore(cwd),
+
+		tools:    []ToolDefinition{},
+		funcs:    map[string]ToolCallFunc{},
+		mutating: map[string]bool{},
+		confirm:  map[string]bool{},
+		shell:    defaultShellOptions,
+		verbose:  verbose,
+
+		pythonOptions: defaultPythonOptions,
 	}
 
 	dispatcher.RegisterTools()
 
+	dispatcher.system = defaultSystemPrompt(dispatcher.tools)
+
 	return dispatcher
 }
 
 func (dispatcher *Dispatcher) readThread() error {
-	filename := filepath.Join(dispatcher.cwd, "thread.aight.json")
-
-	data, err := os.ReadFile(filename)
+	thread, err := dispatcher.threads.Read(dispatcher.threadName)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
 		return err
 	}
 
-	return json.Unmarshal(data, &dispatcher.thread)
-}
-
-func (dispatcher *Dispatcher) saveThread() error {
-	filename := filepath.Join(dispatcher.cwd, "thread.aight.json")
-
-	data, err := json.MarshalIndent(dispatcher.thread, "", "  ")
-	if err != nil {
-		return err
-	}
+	dispatcher.thread = thread
 
-	return os.WriteFile(filename, data, 0644)
+	return nil
 }
 
-func (dispatcher *Dispatcher) sandbox(path string) (string, error) {
-	if path == "/" {
-		return dispatcher.sandbox(".")
-	}
-
-	if filepath.IsAbs(path) {
-		return path, fmt.Errorf("path must be relative: %s", path)
-	}
-
-	if strings.Contains(path, "..") {
-		return path, fmt.Errorf("path must not contain '..': %s", path)
-	}
-
-	return filepath.Join(dispatcher.cwd, path), nil
+func (dispatcher *Dispatcher) saveThread() error {
+	return dispatcher.threads.Save(dispatcher.threadName, dispatcher.thread)
 }
 
 func register[T any](
@@ -118,19 +135,71 @@ func register[T any](
 		break
 	}
 
-	tool := anthropic.ToolDefinition{
+	tool := ToolDefinition{
 		Name:        name,
 		Description: description,
 		InputSchema: reflection,
 	}
 
 	dispatcher.tools = append(dispatcher.tools, tool)
-	dispatcher.funcs[name] = callTool(fn)
+	dispatcher.funcs[name] = callTool(dispatcher, fn)
+}
+
+// registerMutating registers a tool that changes the workspace, such calls
+// are approved by the user before running.
+func registerMutating[T any](
+	dispatcher *Dispatcher,
+	name string,
+	description string,
+	fn func(T) (any, error),
+) {
+	register(dispatcher, name, description, fn)
+
+	dispatcher.mutating[name] = true
+}
+
+// EnableTools keeps only the given tools out of the registered ones.
+func (dispatcher *Dispatcher) EnableTools(names []string) error {
+	enabled := map[string]bool{}
+	for _, name := range names {
+		if _, ok := dispatcher.funcs[name]; !ok {
+			return fmt.Errorf("unknown tool: %s", name)
+		}
+
+		enabled[name] = true
+	}
+
+	tools := []ToolDefinition{}
+	for _, tool := range dispatcher.tools {
+		if enabled[tool.Name] {
+			tools = append(tools, tool)
+		} else {
+			delete(dispatcher.funcs, tool.Name)
+		}
+	}
+
+	dispatcher.tools = tools
+
+	return nil
+}
+
+// DisableMutating keeps only the tools that don't change the workspace.
+func (dispatcher *Dispatcher) DisableMutating() {
+	tools := []ToolDefinition{}
+	for _, tool := range dispatcher.tools {
+		if dispatcher.mutating[tool.Name] {
+			delete(dispatcher.funcs, tool.Name)
+		} else {
+			tools = append(tools, tool)
+		}
+	}
+
+	dispatcher.tools = tools
 }
 
-func (dispatcher *Dispatcher) handleToolCalls(toolUses []anthropic.MessageContentToolUse) error {
+func (dispatcher *Dispatcher) handleToolCalls(toolUses []ToolUse) error {
 	type CallResult struct {
-		Call   anthropic.MessageContentToolUse
+		Call   ToolUse
 		Result any
 		Error  error
 	}
@@ -141,7 +210,7 @@ func (dispatcher *Dispatcher) handleToolCalls(toolUses []anthropic.MessageConten
 	for _, call := range toolUses {
 		assistants.Add(1)
 
-		go func(call anthropic.MessageContentToolUse) {
+		go func(call ToolUse) {
 			defer assistants.Done()
 
 			result, err := dispatcher.callFunction(call)
@@ -187,12 +256,18 @@ func (dispatcher *Dispatcher) handleToolCalls(toolUses []anthropic.MessageConten
 		)
 	}
 
-	content := []anthropic.MessageContent{}
+	content := []Content{}
 	for _, result := range results {
 		var raw []byte
 		var err error
 
-		if result.Error != nil {
+		var denied *PolicyError
+		if errors.As(result.Error, &denied) {
+			raw, err = json.Marshal(denied.Denial)
+			if err != nil {
+				return err
+			}
+		} else if result.Error != nil {
 			raw = []byte(result.Error.Error())
 		} else {
 			raw, err = json.Marshal(result.Result)
@@ -203,7 +278,7 @@ func (dispatcher *Dispatcher) handleToolCalls(toolUses []anthropic.MessageConten
 
 		content = append(
 			content,
-			anthropic.NewToolResultMessageContent(
+			NewToolResultContent(
 				result.Call.ID,
 				string(raw),
 				result.Error != nil,
@@ -211,15 +286,15 @@ func (dispatcher *Dispatcher) handleToolCalls(toolUses []anthropic.MessageConten
 		)
 	}
 
-	dispatcher.WriteToolCall(anthropic.Message{
-		Role:    anthropic.RoleUser,
+	dispatcher.WriteToolCall(Message{
+		Role:    RoleUser,
 		Content: content,
 	})
 
 	return nil
 }
 
-func (dispatcher *Dispatcher) callFunction(call anthropic.MessageContentToolUse) (any, error) {
+func (dispatcher *Dispatcher) callFunction(call ToolUse) (any, error) {
 	fn, ok := dispatcher.funcs[call.Name]
 	if !ok {
 		return nil, errors.New("function not found")
@@ -228,25 +303,42 @@ func (dispatcher *Dispatcher) callFunction(call anthropic.MessageContentToolUse)
 	return fn(call)
 }
 
-func (dispatcher *Dispatcher) WriteMessage(msg anthropic.Message) error {
+func (dispatcher *Dispatcher) WriteMessage(msg Message) error {
 	dispatcher.mutex.Lock()
 	defer dispatcher.mutex.Unlock()
 
 	dispatcher.thread = append(dispatcher.thread, msg)
 	dispatcher.saveThread()
 
+	dispatcher.logMessage(msg)
+
+	return nil
+}
+
+// WriteStreamedMessage stores the message that was already printed by the
+// stream handler.
+func (dispatcher *Dispatcher) WriteStreamedMessage(msg Message) error {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	dispatcher.thread = append(dispatcher.thread, msg)
+
+	return dispatcher.saveThread()
+}
+
+func (dispatcher *Dispatcher) logMessage(msg Message) {
 	var role string
 	switch msg.Role {
-	case anthropic.RoleUser:
+	case RoleUser:
 		role = color.BlueString("user")
-		return nil
-	case anthropic.RoleAssistant:
+		return
+	case RoleAssistant:
 		role = color.RedString("assistant")
 	}
 
 	text := ""
 	if msg.Content != nil && len(msg.Content) > 0 {
-		text = msg.Content[0].GetText()
+		text = msg.Content[0].Text
 	} else {
 		text = silentMarshal(msg.Content)
 	}
@@ -256,17 +348,16 @@ func (dispatcher *Dispatcher) WriteMessage(msg anthropic.Message) error {
 		role,
 		text,
 	)
-
-	return nil
 }
 
 func (dispatcher *Dispatcher) WriteToolCall(
-	msg anthropic.Message,
+	msg Message,
 ) {
 	dispatcher.mutex.Lock()
 	defer dispatcher.mutex.Unlock()
 
 	dispatcher.thread = append(dispatcher.thread, msg)
+	dispatcher.saveThread()
 
 	role := color.MagentaString("tool")
 
@@ -282,32 +373,46 @@ var (
 )
 
 func (dispatcher *Dispatcher) Communicate(prompt func() string) error {
+	err := dispatcher.compact()
+	if err != nil {
+		log.Println(karma.Format(err, "unable to compact thread"))
+	}
+
 	completion, err := dispatcher.complete()
 	if err != nil {
 		return karma.Format(err, "complete")
 	}
 
-	err = dispatcher.WriteMessage(anthropic.Message{
-		Role:    anthropic.RoleAssistant,
+	message := Message{
+		Role:    RoleAssistant,
 		Content: completion.Content,
-	})
-	if err != nil {
-		return karma.Format(err, "write message")
 	}
 
-	var toolUses []anthropic.MessageContentToolUse
-	for _, content := range completion.Content {
-		if content.Type == anthropic.MessagesContentTypeToolUse {
-			toolUses = append(toolUses, *content.MessageContentToolUse)
-		}
+	if completion.Streamed {
+		err = dispatcher.WriteStreamedMessage(message)
+	} else {
+		err = dispatcher.WriteMessage(message)
+	}
+	if err != nil {
+		return karma.Format(err, "write message")
 	}
 
+	toolUses := completion.ToolUses()
 	if len(toolUses) > 0 {
+		turn := len(dispatcher.thread)
+
 		err := dispatcher.handleToolCalls(toolUses)
 		if err != nil {
 			return karma.Format(err, "handle tool calls")
 		}
 
+		if dispatcher.autoCommit && dispatcher.overlay == nil {
+			err := dispatcher.commitTurn(turn, message)
+			if err != nil {
+				log.Println(karma.Format(err, "unable to commit turn %d", turn))
+			}
+		}
+
 		return nil
 	}
 
@@ -322,10 +427,16 @@ func (dispatcher *Dispatcher) interact(prompt func() string) error {
 			continue
 		}
 
-		err := dispatcher.WriteMessage(anthropic.Message{
-			Role:    anthropic.RoleUser,
-			Content: []anthropic.MessageContent{anthropic.NewTextMessageContent(input)},
-		})
+		if input == "/undo" {
+			err := dispatcher.Undo(0)
+			if err != nil {
+				log.Println(karma.Format(err, "unable to undo"))
+			}
+
+			continue
+		}
+
+		err := dispatcher.WriteMessage(NewTextMessage(RoleUser, input))
 		if err != nil {
 			return karma.Format(err, "write message")
 		}
@@ -336,27 +447,54 @@ func (dispatcher *Dispatcher) interact(prompt func() string) error {
 	return nil
 }
 
-func (dispatcher *Dispatcher) complete() (*anthropic.MessagesResponse, error) {
-	for {
+// maxRetries is the number of times a failed request is sent again, the
+// delay between retries doubles from retryDelay.
+const maxRetries = 5
+
+var retryDelay = time.Second
+
+func (dispatcher *Dispatcher) complete() (*Completion, error) {
+	stream := dispatcher.stream
+	delay := retryDelay
+
+	for retry := 0; ; retry++ {
 		requestRateLimit.Take()
 
-		request := anthropic.MessagesRequest{
-			Model:     dispatcher.baseModel,
-			Messages:  dispatcher.thread,
-			MaxTokens: 2000,
-			Tools:     dispatcher.tools,
+		request := CompletionRequest{
+			Model:       dispatcher.baseModel,
+			System:      dispatcher.system,
+			Messages:    dispatcher.thread,
+			MaxTokens:   dispatcher.maxTokens,
+			Temperature: dispatcher.temperature,
+			Tools:       dispatcher.tools,
+			Stream:      stream,
 		}
 
-		response, err := dispatcher.client.CreateMessages(context.Background(), request)
-		if err != nil {
-			time.Sleep(1 * time.Second)
+		completion, err := dispatcher.provider.Complete(context.Background(), request)
+		if err == nil {
+			return completion, nil
+		}
 
-			log.Printf("{%s} request error, retrying... | %s", request.Model, err)
+		if stream != nil {
+			stream.Stop()
 
-			continue
+			// the partial text is already printed, the retried completion
+			// is printed as a whole once it's received
+			stream = nil
+		}
+
+		if retry == maxRetries || !isRetryable(err) {
+			return nil, err
 		}
 
-		return &response, nil
+		log.Printf(
+			"{%s} request error, retrying in %s (%d/%d)... | %s",
+			request.Model, delay, retry+1, maxRetries, err,
+		)
+
+		time.Sleep(delay)
+
+		delay *= 2
 	}
 }
 
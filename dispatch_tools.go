@@ -1,22 +1,38 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
-	_ "github.com/mattn/go-sqlite3"
-
-	"github.com/reconquest/executil-go"
+	"github.com/mattn/go-sqlite3"
 	"github.com/reconquest/karma-go"
 )
 
+// sqliteDriver is sqlite3 without ATTACH DATABASE, since attached
+// databases are opened by paths that the sandbox doesn't check and are
+// created and written even if the database is opened read-only.
+const sqliteDriver = "sqlite3_sandboxed"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			conn.SetLimit(sqlite3.SQLITE_LIMIT_ATTACHED, 0)
+
+			return nil
+		},
+	})
+}
+
 func (dispatcher *Dispatcher) RegisterTools() {
 	register(
 		dispatcher,
@@ -26,35 +42,95 @@ func (dispatcher *Dispatcher) RegisterTools() {
 
 	register(
 		dispatcher,
-		"fs_tree", "Filesystem: List files in the given path recursively. Useful for starting point.",
+		"fs_tree", "Filesystem: List files in the given path recursively as an indented list, directories end with /. Useful for starting point. "+
+			"max_depth is 3 by default, include and exclude are globs of paths like in fs_grep, format is text or json with sizes. "+
+			"Files ignored by .gitignore, .git and node_modules are skipped, \"... N more\" stands for entries over the limits.",
 		guardError(dispatcher.treeFiles),
 	)
 
 	register(
 		dispatcher,
-		"fs_read", "Filesystem: Read file by the given path. Avoid using this for large files. Avoid using it for non-text files like images.",
+		"fs_read", "Filesystem: Read file by the given path, lines are prefixed with their numbers. "+
+			"Optional offset is the number of the first line and limit is the number of lines to read, "+
+			"use them to read large files in parts, next_offset is returned if lines are left. "+
+			"Binary files are only described.",
 		guardError(dispatcher.readFile),
 	)
 
 	register(
+		dispatcher,
+		"fs_grep", "Filesystem: Search files of the given path, the workspace by default, by RE2 regular expression. "+
+			"Returns path:line:text of matching lines, with context lines as path-line-text if context is set. "+
+			"include and exclude are globs of paths, ** matches any directories and a glob without / matches the file name, e.g. *.go. "+
+			"Files ignored by .gitignore and binary files are skipped.",
+		guardError(dispatcher.grep),
+	)
+
+	register(
+		dispatcher,
+		"fs_glob", "Filesystem: Find files of the given path, the workspace by default, by glob, e.g. **/*_test.go or cmd/*/main.go. "+
+			"** matches any directories and a glob without / matches the file name in any directory. "+
+			"Returns paths with sizes, the most recently modified first. Files ignored by .gitignore are skipped.",
+		guardError(dispatcher.glob),
+	)
+
+	register(
+		dispatcher,
+		"go_symbols", "Go: List declarations of the package in the given directory with their positions and signatures, "+
+			"optionally only exported ones or with tests. Useful to get an overview of a package without reading its files.",
+		guardError(dispatcher.goSymbols),
+	)
+
+	register(
+		dispatcher,
+		"go_definition", "Go: Find the definition of the symbol of the package in the given directory, "+
+			"symbol is a package-level name, Type.Method or Type.Field. Returns its position, signature and doc comment.",
+		guardError(dispatcher.goDefinition),
+	)
+
+	register(
+		dispatcher,
+		"go_references", "Go: Find references to the symbol of the package in the given directory in all packages of the workspace, "+
+			"symbol is a package-level name, Type.Method or Type.Field. Returns path:line:column: text of every reference.",
+		guardError(dispatcher.goReferences),
+	)
+
+	register(
+		dispatcher,
+		"go_source", "Go: Return the source of the declaration of the symbol of the package in the given directory with its doc comment, "+
+			"symbol is a package-level name, Type.Method or Type.Field. Prefer it over fs_read to read a function.",
+		guardError(dispatcher.goSource),
+	)
+
+	dispatcher.registerGit()
+
+	registerMutating(
 		dispatcher,
 		"fs_write", "Filesystem: Write file by the given path.",
 		guardError(dispatcher.writeFile),
 	)
 
-	register(
+	registerMutating(
+		dispatcher,
+		"fs_edit", "Filesystem: Replace old_text with new_text in the file by the given path and return the diff. "+
+			"old_text must match the file exactly, including indentation, and be unique unless replace_all is set, "+
+			"include a few surrounding lines to make it unique. Prefer it over fs_patch and fs_write for changes of existing files.",
+		guardError(dispatcher.editFile),
+	)
+
+	registerMutating(
 		dispatcher,
 		"fs_move", "Filesystem: Move file",
 		guardError(dispatcher.moveFile),
 	)
 
-	register(
+	registerMutating(
 		dispatcher,
 		"fs_remove", "Filesystem: Remove file",
 		guardError(dispatcher.removeFile),
 	)
 
-	register(
+	registerMutating(
 		dispatcher,
 		"sql_exec", "SQLite: execute statement and return result (rows affected, last insert id)",
 		guardError(dispatcher.sqlExec),
@@ -66,24 +142,34 @@ func (dispatcher *Dispatcher) RegisterTools() {
 		guardError(dispatcher.sqlQuery),
 	)
 
-	register(
+	registerMutating(
 		dispatcher,
 		"fs_patch",
-		"Apply a patch to a file",
+		"Filesystem: Apply a unified or git diff to one or more files, including created, removed and renamed ones. "+
+			"Hunks are matched by their lines rather than the line numbers of headers, with small offsets and whitespace differences allowed. "+
+			"Either all files are changed or none, the result reports every hunk.",
 		guardError(dispatcher.patchFile),
 	)
 
-	//register(
-	//    dispatcher,
-	//    "python_execute", "Execute python code. This is especially useful for math.",
-	//    guardError(dispatcher.python),
-	//)
+	registerMutating(
+		dispatcher,
+		"shell_exec", "Shell: Run the command by sh in the workspace and return its exit code and combined output. "+
+			"Optional dir is relative to the workspace, optional timeout is in seconds. "+
+			"Useful to build and test changes, e.g. go test ./... or make.",
+		guardError(dispatcher.shellExec),
+	)
+
+	dispatcher.registerPython()
 }
 
 type ListFilesArguments struct {
 	Path string `json:"path"`
 }
 
+func (args ListFilesArguments) Paths() []string {
+	return []string{args.Path}
+}
+
 func (dispatcher *Dispatcher) listFiles(args ListFilesArguments) (any, error) {
 	type File struct {
 		Name string `json:"name"`
@@ -98,9 +184,22 @@ func (dispatcher *Dispatcher) listFiles(args ListFilesArguments) (any, error) {
 		return nil, err
 	}
 
+	// files changed in dry-run mode, the ones left after listing the
+	// directory are new
+	var changes []OverlayEntry
+	if dispatcher.overlay != nil {
+		name, _ := filepath.Rel(dispatcher.cwd, path)
+		changes = dispatcher.overlay.Entries(name)
+	}
+
+	changed := map[string]OverlayEntry{}
+	for _, entry := range changes {
+		changed[entry.Name] = entry
+	}
+
 	files, err := os.ReadDir(path)
-	if err != nil {
-		return nil, karma.Format(err, "read dir: %s", path)
+	if err != nil && !(os.IsNotExist(err) && len(changes) > 0) {
+		return nil, karma.Format(relativeError(dispatcher.cwd, err), "read dir: %s", args.Path)
 	}
 
 	for _, file := range files {
@@ -114,6 +213,18 @@ func (dispatcher *Dispatcher) listFiles(args ListFilesArguments) (any, error) {
 			size = info.Size()
 		}
 
+		if entry, ok := changed[file.Name()]; ok {
+			delete(changed, file.Name())
+
+			if entry.Removed {
+				continue
+			}
+
+			if !entry.Dir {
+				size = entry.Size
+			}
+		}
+
 		result = append(result, File{
 			Name: file.Name(),
 			Dir:  file.IsDir(),
@@ -121,25 +232,225 @@ func (dispatcher *Dispatcher) listFiles(args ListFilesArguments) (any, error) {
 		})
 	}
 
+	for _, entry := range changes {
+		if _, ok := changed[entry.Name]; !ok || entry.Removed {
+			continue
+		}
+
+		result = append(result, File{
+			Name: entry.Name,
+			Dir:  entry.Dir,
+			Size: entry.Size,
+		})
+	}
+
 	return result, nil
 }
 
+const (
+	// maxReadFileSize is the size of the largest file fs_read loads
+	maxReadFileSize = 16 << 20
+
+	// maxReadOutput is the byte budget of fs_read output, the lines after
+	// it are left for the next call
+	maxReadOutput = 64 << 10
+)
+
 type ReadFileArguments struct {
 	Path string `json:"path"`
+
+	// Offset is the number of the first line to read, starting from 1
+	Offset int `json:"offset,omitempty"`
+
+	// Limit is the maximum number of lines to read
+	Limit int `json:"limit,omitempty"`
+}
+
+func (args ReadFileArguments) Paths() []string {
+	return []string{args.Path}
+}
+
+type ReadFileResult struct {
+	Contents   string `json:"contents,omitempty"`
+	TotalLines int    `json:"total_lines"`
+	FirstLine  int    `json:"first_line,omitempty"`
+	LastLine   int    `json:"last_line,omitempty"`
+	NextOffset int    `json:"next_offset,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	Binary     string `json:"binary,omitempty"`
 }
 
 func (dispatcher *Dispatcher) readFile(args ReadFileArguments) (any, error) {
-	path, err := dispatcher.sandbox(args.Path)
+	if args.Offset < 0 || args.Limit < 0 {
+		return nil, errors.New("offset and limit must not be negative")
+	}
+
+	err := dispatcher.checkReadSize(args.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	contents, err := os.ReadFile(path)
+	contents, err := dispatcher.readWorkspaceFile(args.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	return string(contents), nil
+	if len(contents) > maxReadFileSize {
+		return nil, fmt.Errorf(
+			"file is too large: %d bytes, at most %d bytes can be read",
+			len(contents), maxReadFileSize,
+		)
+	}
+
+	if isBinary(contents) || !utf8.Valid(contents) {
+		return ReadFileResult{
+			Binary: fmt.Sprintf(
+				"%s, %d bytes",
+				http.DetectContentType(contents), len(contents),
+			),
+		}, nil
+	}
+
+	lines := strings.SplitAfter(string(contents), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	result := ReadFileResult{TotalLines: len(lines)}
+	if len(lines) == 0 {
+		return result, nil
+	}
+
+	first := max(args.Offset, 1)
+	if first > len(lines) {
+		return nil, fmt.Errorf(
+			"offset %d is beyond the end of the file of %d lines",
+			args.Offset, len(lines),
+		)
+	}
+
+	last := len(lines)
+	if args.Limit > 0 {
+		last = min(first+args.Limit-1, len(lines))
+	}
+
+	width := len(strconv.Itoa(last))
+
+	var buffer strings.Builder
+	for number := first; number <= last; number++ {
+		line := fmt.Sprintf("%*d\t%s", width, number, lines[number-1])
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+
+		if buffer.Len()+len(line) > maxReadOutput {
+			result.Truncated = true
+
+			if number > first {
+				last = number - 1
+				break
+			}
+
+			// a single line over the budget is cut, the rest of it can't
+			// be read with fs_read
+			line = truncateUTF8(line, maxReadOutput-1) + "\n"
+			last = number
+			buffer.WriteString(line)
+			break
+		}
+
+		buffer.WriteString(line)
+	}
+
+	result.Contents = buffer.String()
+	result.FirstLine = first
+	result.LastLine = last
+
+	if last < len(lines) {
+		result.NextOffset = last + 1
+	}
+
+	return result, nil
+}
+
+// truncateUTF8 cuts the text to at most size bytes without splitting
+// multibyte characters.
+func truncateUTF8(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+
+	return text[:size]
+}
+
+// checkReadSize refuses files larger than maxReadFileSize before they are
+// loaded into memory.
+func (dispatcher *Dispatcher) checkReadSize(path string) error {
+	if dispatcher.overlay != nil {
+		_, ok, _ := dispatcher.overlay.Read(path)
+		if ok {
+			return nil
+		}
+	}
+
+	fd, err := dispatcher.open(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return karma.Format(err, "stat file: %s", path)
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory, use fs_list", path)
+	}
+
+	if info.Size() > maxReadFileSize {
+		return fmt.Errorf(
+			"file is too large: %d bytes, at most %d bytes can be read",
+			info.Size(), maxReadFileSize,
+		)
+	}
+
+	return nil
+}
+
+// readWorkspaceFile reads the file of the workspace, in dry-run mode with
+// simulated changes.
+func (dispatcher *Dispatcher) readWorkspaceFile(path string) ([]byte, error) {
+	if dispatcher.overlay != nil {
+		_, err := dispatcher.sandbox(path)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, ok, err := dispatcher.overlay.Read(path)
+		if ok {
+			return contents, err
+		}
+	}
+
+	fd, err := dispatcher.open(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	contents, err := io.ReadAll(fd)
+	if err != nil {
+		return nil, karma.Format(err, "read file: %s", path)
+	}
+
+	return contents, nil
 }
 
 type WriteFileArguments struct {
@@ -148,15 +459,36 @@ type WriteFileArguments struct {
 	Append   bool   `json:"append"`
 }
 
+func (args WriteFileArguments) Paths() []string {
+	return []string{args.Path}
+}
+
 func (dispatcher *Dispatcher) writeFile(args WriteFileArguments) (any, error) {
-	path, err := dispatcher.sandbox(args.Path)
+	path, err := dispatcher.sandboxWrite(args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if dispatcher.overlay != nil {
+		err := dispatcher.overlay.Write(args.Path, []byte(args.Contents), args.Append)
+		if err != nil {
+			return nil, err
+		}
+
+		return true, nil
+	}
+
+	err = dispatcher.checkpoint(path)
 	if err != nil {
 		return nil, err
 	}
 
 	err = os.MkdirAll(filepath.Dir(path), 0755)
 	if err != nil {
-		return nil, karma.Format(err, "create directory: %s", filepath.Dir(path))
+		return nil, karma.Format(
+			relativeError(dispatcher.cwd, err),
+			"create directory: %s", filepath.Dir(args.Path),
+		)
 	}
 
 	flags := os.O_CREATE | os.O_WRONLY
@@ -166,16 +498,16 @@ func (dispatcher *Dispatcher) writeFile(args WriteFileArguments) (any, error) {
 		flags |= os.O_TRUNC
 	}
 
-	fd, err := os.OpenFile(path, flags, 0644)
+	fd, err := dispatcher.open(args.Path, flags, 0644)
 	if err != nil {
-		return nil, karma.Format(err, "open file: %s", path)
+		return nil, karma.Format(err, "open file: %s", args.Path)
 	}
 
 	defer fd.Close()
 
 	_, err = fd.WriteString(args.Contents)
 	if err != nil {
-		return nil, karma.Format(err, "write file: %s", path)
+		return nil, karma.Format(err, "write file: %s", args.Path)
 	}
 
 	return true, nil
@@ -186,67 +518,77 @@ type MoveFileArguments struct {
 	To   string `json:"to"`
 }
 
+func (args MoveFileArguments) Paths() []string {
+	return []string{args.From, args.To}
+}
+
 func (dispatcher *Dispatcher) moveFile(args MoveFileArguments) (any, error) {
-	from, err := dispatcher.sandbox(args.From)
+	from, err := dispatcher.sandboxWrite(args.From)
 	if err != nil {
 		return nil, err
 	}
 
-	to, err := dispatcher.sandbox(args.To)
+	to, err := dispatcher.sandboxWrite(args.To)
 	if err != nil {
 		return nil, err
 	}
 
-	err = os.Rename(from, to)
-	if err != nil {
-		return nil, karma.Format(err, "rename file")
-	}
-
-	return true, nil
-}
+	if dispatcher.overlay != nil {
+		err := dispatcher.overlay.Move(args.From, args.To)
+		if err != nil {
+			return nil, err
+		}
 
-type RemoveFileArguments struct {
-	Path string `json:"path"`
-}
+		return true, nil
+	}
 
-func (dispatcher *Dispatcher) removeFile(args RemoveFileArguments) (any, error) {
-	path, err := dispatcher.sandbox(args.Path)
+	err = dispatcher.checkpoint(from, to)
 	if err != nil {
 		return nil, err
 	}
 
-	err = os.Remove(path)
+	err = os.Rename(from, to)
 	if err != nil {
-		return nil, karma.Format(err, "remove file")
+		return nil, karma.Format(relativeError(dispatcher.cwd, err), "rename file")
 	}
 
 	return true, nil
 }
 
-type TreeFilesArguments struct {
+type RemoveFileArguments struct {
 	Path string `json:"path"`
 }
 
-func (dispatcher *Dispatcher) treeFiles(args TreeFilesArguments) (any, error) {
-	path, err := dispatcher.sandbox(args.Path)
+func (args RemoveFileArguments) Paths() []string {
+	return []string{args.Path}
+}
+
+func (dispatcher *Dispatcher) removeFile(args RemoveFileArguments) (any, error) {
+	path, err := dispatcher.sandboxWrite(args.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	cmd := exec.Command("tree", "-J", path)
+	if dispatcher.overlay != nil {
+		err := dispatcher.overlay.Remove(args.Path)
+		if err != nil {
+			return nil, err
+		}
 
-	stdout, _, err := executil.Run(cmd)
+		return true, nil
+	}
+
+	err = dispatcher.checkpoint(path)
 	if err != nil {
-		return nil, karma.Format(err, "run tree")
+		return nil, err
 	}
 
-	var result any
-	err = json.Unmarshal(stdout, &result)
+	err = os.Remove(path)
 	if err != nil {
-		return nil, karma.Format(err, "unmarshal tree")
+		return nil, karma.Format(relativeError(dispatcher.cwd, err), "remove file")
 	}
 
-	return result, nil
+	return true, nil
 }
 
 type SQLExecArguments struct {
@@ -254,17 +596,28 @@ type SQLExecArguments struct {
 	Query    string `json:"query"`
 }
 
+func (args SQLExecArguments) Paths() []string {
+	return []string{args.Database}
+}
+
 func (arguments SQLExecArguments) String() string {
 	return arguments.Query
 }
 
 func (dispatcher *Dispatcher) sqlExec(args SQLExecArguments) (any, error) {
-	path, err := dispatcher.sandbox(args.Database)
+	path, err := dispatcher.sandboxWrite(args.Database)
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", path)
+	if dispatcher.overlay != nil {
+		path, err = dispatcher.overlay.Database(args.Database, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open(sqliteDriver, path)
 	if err != nil {
 		return nil, karma.Format(err, "open database")
 	}
@@ -296,6 +649,10 @@ type SQLQueryArguments struct {
 	Query    string `json:"query"`
 }
 
+func (args SQLQueryArguments) Paths() []string {
+	return []string{args.Database}
+}
+
 func (arguments SQLQueryArguments) String() string {
 	return arguments.Query
 }
@@ -306,9 +663,16 @@ func (dispatcher *Dispatcher) sqlQuery(args SQLQueryArguments) (any, error) {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", path)
+	if dispatcher.overlay != nil {
+		path, err = dispatcher.overlay.Database(args.Database, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := openReadOnlyDatabase(path)
 	if err != nil {
-		return nil, karma.Format(err, "open database")
+		return nil, err
 	}
 
 	defer db.Close()
@@ -349,100 +713,48 @@ func (dispatcher *Dispatcher) sqlQuery(args SQLQueryArguments) (any, error) {
 		result = append(result, row)
 	}
 
-	return result, nil
-}
-
-type PythonArguments struct {
-	ScriptName string `json:"script_name"`
-	Code       string `json:"code"`
-}
+	err = rows.Err()
+	if err != nil {
+		return nil, karma.Format(err, "execute query")
+	}
 
-func (arguments PythonArguments) String() string {
-	return arguments.ScriptName + "\n" + arguments.Code
+	return result, nil
 }
 
-func (dispatcher *Dispatcher) python(args PythonArguments) (any, error) {
-	path, err := dispatcher.sandbox(args.ScriptName)
+// openReadOnlyDatabase opens an existing database without the ability to
+// change it, so sql_query can't create tables or the database file itself
+// in --read-only and --dry-run modes.
+func openReadOnlyDatabase(path string) (*sql.DB, error) {
+	_, err := os.Stat(path)
 	if err != nil {
-		return nil, err
-	}
+		if os.IsNotExist(err) {
+			return nil, errors.New("database does not exist")
+		}
 
-	if !strings.HasSuffix(path, ".py") {
-		path += ".py"
+		return nil, karma.Format(err, "open database")
 	}
 
-	err = os.WriteFile(path, []byte(args.Code), 0644)
-	if err != nil {
-		return nil, karma.Format(err, "write python code")
-	}
+	dsn := (&url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}).String()
 
-	stdout, stderr, err := executil.Run(
-		exec.Command("python3", path),
-	)
+	db, err := sql.Open(sqliteDriver, dsn)
 	if err != nil {
-		return nil, karma.Format(err, "run python code")
-	}
-
-	if len(stdout) == 0 && len(stderr) == 0 {
-		return "ok", nil
+		return nil, karma.Format(err, "open database")
 	}
 
-	return map[string]string{
-		"stdout": string(stdout),
-		"stderr": string(stderr),
-	}, nil
+	return db, nil
 }
 
-// guardError returns error as first argument if it is not nil
+// guardError returns error message as first argument if it is not nil. The
+// message is returned as a string, since error values are marshaled to the
+// model as empty JSON objects.
 func guardError[T any](fn func(T) (any, error)) func(T) (any, error) {
 	return func(x T) (any, error) {
 		v, err := fn(x)
 		if err != nil {
 			log.Println(err)
-			return karma.Flatten(err), nil
+			return karma.Flatten(err).Error(), nil
 		}
 
 		return v, nil
 	}
 }
-
-type PatchFileArguments struct {
-	Patch string `json:"patch"`
-}
-
-func (dispatcher *Dispatcher) patchFile(args PatchFileArguments) (any, error) {
-	cmd := exec.Command("patch", "-p1", "-u")
-
-	cmd.Dir = dispatcher.cwd
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-
-	buffer := bytes.NewBuffer(nil)
-	cmd.Stdout = buffer
-	cmd.Stderr = buffer
-
-	err = cmd.Start()
-	if err != nil {
-		return nil, karma.Format(err, "start patch")
-	}
-
-	_, err = io.WriteString(stdin, args.Patch+"\n")
-	if err != nil {
-		return nil, karma.Format(err, "write patch")
-	}
-
-	err = stdin.Close()
-	if err != nil {
-		return nil, karma.Format(err, "close patch")
-	}
-
-	err = cmd.Wait()
-	if err != nil {
-		return nil, karma.Format(err, "run patch")
-	}
-
-	return buffer.String(), nil
-}
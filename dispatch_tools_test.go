@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/reconquest/karma-go"
+)
+
+func TestDispatcher_ReadFile(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	var lines []string
+	for i := 1; i <= 12; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	files := map[string]string{
+		"lines.txt": strings.Join(lines, "\n"),
+		"empty.txt": "",
+		"image.png": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"large.txt": strings.Repeat(strings.Repeat("x", 99)+"\n", 1000),
+		"long.txt":  strings.Repeat("é", maxReadOutput) + "\nshort\n",
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dispatcher.cwd, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args     ReadFileArguments
+		expected ReadFileResult
+	}{
+		{
+			ReadFileArguments{Path: "lines.txt", Offset: 9, Limit: 2},
+			ReadFileResult{
+				Contents:   " 9\tline 9\n10\tline 10\n",
+				TotalLines: 12,
+				FirstLine:  9,
+				LastLine:   10,
+				NextOffset: 11,
+			},
+		},
+		{
+			ReadFileArguments{Path: "lines.txt", Offset: 12},
+			ReadFileResult{
+				Contents:   "12\tline 12\n",
+				TotalLines: 12,
+				FirstLine:  12,
+				LastLine:   12,
+			},
+		},
+		{
+			ReadFileArguments{Path: "empty.txt"},
+			ReadFileResult{},
+		},
+		{
+			ReadFileArguments{Path: "image.png"},
+			ReadFileResult{Binary: "image/png, 16 bytes"},
+		},
+	}
+	for _, test := range tests {
+		result, err := dispatcher.readFile(test.args)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", test.args, err)
+			continue
+		}
+
+		if result != test.expected {
+			t.Errorf("%+v: expected %#v, got %#v", test.args, test.expected, result)
+		}
+	}
+
+	result, err := dispatcher.readFile(ReadFileArguments{Path: "large.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := result.(ReadFileResult)
+	if !large.Truncated || large.NextOffset != large.LastLine+1 ||
+		len(large.Contents) > maxReadOutput || large.TotalLines != 1000 {
+		t.Errorf("expected large.txt to be truncated, got %+v", large)
+	}
+
+	result, err = dispatcher.readFile(ReadFileArguments{Path: "long.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := result.(ReadFileResult)
+	if !long.Truncated || long.FirstLine != 1 || long.LastLine != 1 || long.NextOffset != 2 ||
+		len(long.Contents) > maxReadOutput || !utf8.ValidString(long.Contents) ||
+		!strings.HasPrefix(long.Contents, "1\té") {
+		t.Errorf("expected the long line to be cut, got %d bytes: %+v",
+			len(long.Contents), long.Truncated)
+	}
+
+	_, err = dispatcher.readFile(ReadFileArguments{Path: "lines.txt", Offset: 13})
+	if err == nil || !strings.Contains(err.Error(), "beyond the end of the file of 12 lines") {
+		t.Errorf("expected offset error, got %v", err)
+	}
+}
+
+func TestDispatcher_SQLQueryReadOnly(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+	dispatcher.DisableMutating()
+
+	_, err := dispatcher.sqlQuery(SQLQueryArguments{
+		Database: "x.db",
+		Query:    "CREATE TABLE t (a INT)",
+	})
+	if err == nil {
+		t.Error("expected query on a missing database to fail")
+	}
+
+	if _, err := os.Stat(filepath.Join(dispatcher.cwd, "x.db")); !os.IsNotExist(err) {
+		t.Errorf("database must not be created, got %v", err)
+	}
+
+	_, err = dispatcher.sqlExec(SQLExecArguments{
+		Database: "test.db",
+		Query:    "CREATE TABLE a (id INT); INSERT INTO a VALUES (1)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dispatcher.sqlQuery(SQLQueryArguments{
+		Database: "test.db",
+		Query:    "CREATE TABLE t (a INT)",
+	})
+	if err == nil || !strings.Contains(err.Error(), "readonly") {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+
+	rows, err := dispatcher.sqlQuery(SQLQueryArguments{
+		Database: "test.db",
+		Query:    "SELECT name FROM sqlite_master",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(rows) != "[map[name:a]]" {
+		t.Errorf("unexpected tables: %v", rows)
+	}
+}
+
+func TestDispatcher_SQLQueryDryRun(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	var err error
+	dispatcher.overlay, err = NewOverlay(dispatcher.cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dispatcher.overlay.Close()
+
+	_, err = dispatcher.sqlQuery(SQLQueryArguments{
+		Database: "y.db",
+		Query:    "CREATE TABLE t (a INT)",
+	})
+	if err == nil {
+		t.Error("expected query on a missing database to fail")
+	}
+
+	if _, err := os.Stat(filepath.Join(dispatcher.cwd, "y.db")); !os.IsNotExist(err) {
+		t.Errorf("database must not be created, got %v", err)
+	}
+
+	if diff := dispatcher.overlay.Diff(); diff != "" {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+}
+
+func TestDispatcher_SQLAttach(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	outside := filepath.Join(t.TempDir(), "outside.db")
+
+	_, err := dispatcher.sqlExec(SQLExecArguments{
+		Database: "test.db",
+		Query: "CREATE TABLE a (id INT); " +
+			"ATTACH DATABASE '" + outside + "' AS x; CREATE TABLE x.t (a INT)",
+	})
+	if err == nil || !strings.Contains(err.Error(), "too many attached databases") {
+		t.Errorf("expected sql_exec to refuse attaching, got %v", err)
+	}
+
+	_, err = dispatcher.sqlQuery(SQLQueryArguments{
+		Database: "test.db",
+		Query:    "ATTACH DATABASE '" + outside + "' AS x",
+	})
+	if err == nil || !strings.Contains(err.Error(), "too many attached databases") {
+		t.Errorf("expected sql_query to refuse attaching, got %v", err)
+	}
+
+	// VACUUM INTO writes a copy by attaching it
+	_, err = dispatcher.sqlExec(SQLExecArguments{
+		Database: "test.db",
+		Query:    "VACUUM INTO '" + outside + "'",
+	})
+	if err == nil || !strings.Contains(err.Error(), "too many attached databases") {
+		t.Errorf("expected sql_exec to refuse vacuum into a file, got %v", err)
+	}
+
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Errorf("database outside of the workspace must not be created, got %v", err)
+	}
+}
+
+func TestGuardError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{errors.New("plain"), `"plain"`},
+		{karma.Format(errors.New("inner"), "outer"), `"outer: inner"`},
+	}
+	for _, test := range tests {
+		guarded := guardError(func(struct{}) (any, error) {
+			return nil, test.err
+		})
+
+		result, err := guarded(struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if marshaled := silentMarshal(result); marshaled != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, marshaled)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+type EditFileArguments struct {
+	Path       string `json:"path"`
+	OldText    string `json:"old_text"`
+	NewText    string `json:"new_text"`
+	ReplaceAll bool   `json:"replace_all,omitempty"`
+}
+
+func (args EditFileArguments) Paths() []string {
+	return []string{args.Path}
+}
+
+type EditFileResult struct {
+	Replacements int    `json:"replacements"`
+	Diff         string `json:"diff"`
+}
+
+// apply replaces the old text in the contents and returns the result with
+// the number of replacements.
+func (args EditFileArguments) apply(contents string) (string, int, error) {
+	if args.OldText == "" {
+		return "", 0, errors.New("old_text is empty, use fs_write to create or overwrite a file")
+	}
+
+	if args.OldText == args.NewText {
+		return "", 0, errors.New("old_text and new_text are the same")
+	}
+
+	count := strings.Count(contents, args.OldText)
+	switch {
+	case count == 0:
+		return "", 0, notFoundError(args.Path, contents, args.OldText)
+
+	case count > 1 && !args.ReplaceAll:
+		var lines []string
+		for offset := 0; ; {
+			index := strings.Index(contents[offset:], args.OldText)
+			if index < 0 {
+				break
+			}
+
+			offset += index
+			lines = append(lines, fmt.Sprint(strings.Count(contents[:offset], "\n")+1))
+			offset += len(args.OldText)
+		}
+
+		return "", 0, fmt.Errorf(
+			"old_text is found %d times in %s, at lines %s, "+
+				"include more surrounding lines to make it unique or set replace_all",
+			count, args.Path, strings.Join(lines, ", "),
+		)
+	}
+
+	return strings.Replace(contents, args.OldText, args.NewText, count), count, nil
+}
+
+// notFoundError explains why the text is not found, the model often gets
+// indentation or trailing spaces wrong.
+func notFoundError(path string, contents string, text string) error {
+	normalize := func(text string) string {
+		return strings.Join(strings.Fields(text), " ")
+	}
+
+	if normalize(text) != "" && strings.Contains(normalize(contents), normalize(text)) {
+		hint := ""
+
+		first := strings.TrimSpace(strings.SplitN(strings.TrimSpace(text), "\n", 2)[0])
+		for number, line := range strings.Split(contents, "\n") {
+			if strings.TrimSpace(line) == first {
+				hint = fmt.Sprintf(" near line %d", number+1)
+				break
+			}
+		}
+
+		return fmt.Errorf(
+			"old_text is not found in %s, but it matches%s if whitespace is ignored, "+
+				"copy the text exactly including indentation",
+			path, hint,
+		)
+	}
+
+	return fmt.Errorf(
+		"old_text is not found in %s, read the file with fs_read to get the exact text",
+		path,
+	)
+}
+
+func (dispatcher *Dispatcher) editFile(args EditFileArguments) (any, error) {
+	path, err := dispatcher.sandboxWrite(args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := dispatcher.readWorkspaceFile(args.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s does not exist, use fs_write to create it", args.Path)
+		}
+
+		return nil, err
+	}
+
+	if isBinary(contents) {
+		return nil, fmt.Errorf("%s is a binary file", args.Path)
+	}
+
+	edited, count, err := args.apply(string(contents))
+	if err != nil {
+		return nil, err
+	}
+
+	result := EditFileResult{
+		Replacements: count,
+		Diff:         unifiedDiff(args.Path, string(contents), edited, false, false),
+	}
+
+	if dispatcher.overlay != nil {
+		err := dispatcher.overlay.Write(args.Path, []byte(edited), false)
+		if err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+
+	err = dispatcher.checkpoint(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := dispatcher.open(args.Path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return nil, karma.Format(err, "open file: %s", args.Path)
+	}
+
+	defer fd.Close()
+
+	_, err = fd.WriteString(edited)
+	if err != nil {
+		return nil, karma.Format(err, "write file: %s", args.Path)
+	}
+
+	return result, nil
+}
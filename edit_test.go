@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDispatcher_EditFile(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	path := filepath.Join(dispatcher.cwd, "main.go")
+
+	original := "package main\n\nfunc main() {\n\tprintln(1)\n\tprintln(1)\n}\n"
+
+	err := os.WriteFile(path, []byte(original), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := []struct {
+		args     EditFileArguments
+		expected string
+	}{
+		{
+			EditFileArguments{OldText: "println(1)", NewText: "println(2)"},
+			"old_text is found 2 times in main.go, at lines 4, 5",
+		},
+		{
+			EditFileArguments{OldText: "func main() {\n  println(1)", NewText: "x"},
+			"matches near line 3 if whitespace is ignored",
+		},
+		{
+			EditFileArguments{OldText: "println(3)", NewText: "x"},
+			"old_text is not found in main.go, read the file",
+		},
+		{
+			EditFileArguments{OldText: "", NewText: "x"},
+			"old_text is empty",
+		},
+	}
+	for _, test := range failures {
+		test.args.Path = "main.go"
+
+		_, err := dispatcher.editFile(test.args)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected error %q, got %v", test.args.OldText, test.expected, err)
+		}
+	}
+
+	result, err := dispatcher.editFile(EditFileArguments{
+		Path:       "main.go",
+		OldText:    "println(1)",
+		NewText:    "println(2)",
+		ReplaceAll: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.(EditFileResult).Replacements != 2 {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(contents) != strings.ReplaceAll(original, "println(1)", "println(2)") {
+		t.Errorf("unexpected contents:\n%s", contents)
+	}
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/reconquest/executil-go"
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	gitDefaultLogCount = 20
+	gitMaxLogCount     = 200
+
+	// gitBranchPrefix is the prefix of branches turns are committed to by
+	// auto-commit, the thread name follows it
+	gitBranchPrefix = "aight/"
+
+	gitMaxSubject   = 72
+	gitMaxToolInput = 200
+)
+
+type GitResult struct {
+	Output    string `json:"output"`
+	Truncated int    `json:"truncated,omitempty"`
+}
+
+type GitStatusArguments struct {
+	Path string `json:"path,omitempty"`
+}
+
+func (args GitStatusArguments) Paths() []string {
+	return []string{gitPath(args.Path)}
+}
+
+type GitDiffArguments struct {
+	// Ref is the commit to compare with, the index by default
+	Ref string `json:"ref,omitempty"`
+
+	// Staged compares the index instead of the working tree
+	Staged bool `json:"staged,omitempty"`
+
+	Path string `json:"path,omitempty"`
+	Stat bool   `json:"stat,omitempty"`
+}
+
+func (args GitDiffArguments) Paths() []string {
+	return []string{gitPath(args.Path)}
+}
+
+type GitLogArguments struct {
+	Ref      string `json:"ref,omitempty"`
+	Path     string `json:"path,omitempty"`
+	MaxCount int    `json:"max_count,omitempty"`
+}
+
+func (args GitLogArguments) Paths() []string {
+	return []string{gitPath(args.Path)}
+}
+
+type GitShowArguments struct {
+	// Revision is a commit, HEAD by default
+	Revision string `json:"revision,omitempty"`
+
+	// Path shows the file at the revision instead of the commit
+	Path string `json:"path,omitempty"`
+}
+
+func (args GitShowArguments) Paths() []string {
+	return []string{gitPath(args.Path)}
+}
+
+func gitPath(path string) string {
+	if path == "" {
+		return "."
+	}
+
+	return path
+}
+
+func (dispatcher *Dispatcher) registerGit() {
+	register(
+		dispatcher,
+		"git_status", "Git: Show the branch and changed files of the workspace repository, optionally of the given path.",
+		guardError(dispatcher.gitStatus),
+	)
+
+	register(
+		dispatcher,
+		"git_diff", "Git: Show changes of the working tree not staged yet, or staged ones if staged is set. "+
+			"Optional ref compares with the commit instead, path limits the diff and stat returns only changed files.",
+		guardError(dispatcher.gitDiff),
+	)
+
+	register(
+		dispatcher,
+		"git_log", "Git: List commits as hash date author subject, the most recent first. "+
+			fmt.Sprintf("Optional ref is the commit to start from, path limits commits to ones changing it, max_count is %d by default.", gitDefaultLogCount),
+		guardError(dispatcher.gitLog),
+	)
+
+	register(
+		dispatcher,
+		"git_show", "Git: Show the commit message and changes of the revision, HEAD by default, "+
+			"or the contents of the file by the given path at the revision if path is set.",
+		guardError(dispatcher.gitShow),
+	)
+}
+
+func (dispatcher *Dispatcher) gitStatus(args GitStatusArguments) (any, error) {
+	path, err := dispatcher.gitPathspec(args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	excludes, err := dispatcher.gitExcludes(
+		"git_status",
+		"status", "--porcelain", "-z", "--untracked-files=all", "--", path,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return dispatcher.gitOutput(
+		append([]string{"status", "--short", "--branch", "--", path}, excludes...)...,
+	)
+}
+
+func (dispatcher *Dispatcher) gitDiff(args GitDiffArguments) (any, error) {
+	path, err := dispatcher.gitPathspec(args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// compared trees are the same for listing and showing the changes
+	trees := []string{}
+	if args.Staged {
+		trees = append(trees, "--cached")
+	}
+
+	if args.Ref != "" {
+		err := checkGitRef(args.Ref)
+		if err != nil {
+			return nil, err
+		}
+
+		trees = append(trees, args.Ref)
+	}
+
+	excludes, err := dispatcher.gitExcludes(
+		"git_diff",
+		append(append([]string{"diff", "--name-only", "-z", "--no-renames"}, trees...), "--", path)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{"diff", "--no-color", "--no-ext-diff", "--no-textconv"}
+	if args.Stat {
+		command = append(command, "--stat")
+	}
+
+	command = append(append(command, trees...), "--", path)
+
+	return dispatcher.gitOutput(append(command, excludes...)...)
+}
+
+func (dispatcher *Dispatcher) gitLog(args GitLogArguments) (any, error) {
+	path, err := dispatcher.gitPathspec(args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	count := args.MaxCount
+	if count <= 0 {
+		count = gitDefaultLogCount
+	}
+
+	count = min(count, gitMaxLogCount)
+
+	command := []string{
+		"log", "--no-color", "--date=short", "--format=%h %ad %an %s",
+		fmt.Sprintf("--max-count=%d", count),
+	}
+
+	if args.Ref != "" {
+		err := checkGitRef(args.Ref)
+		if err != nil {
+			return nil, err
+		}
+
+		command = append(command, args.Ref)
+	}
+
+	return dispatcher.gitOutput(append(command, "--", path)...)
+}
+
+func (dispatcher *Dispatcher) gitShow(args GitShowArguments) (any, error) {
+	revision := args.Revision
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	err := checkGitRef(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dispatcher.checkGitRepository()
+	if err != nil {
+		return nil, err
+	}
+
+	// only commits are shown, trees and blobs would bypass the path rules
+	commit, err := dispatcher.git(nil, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("revision %q is not a commit", revision)
+	}
+
+	revision = strings.TrimSpace(commit)
+
+	if args.Path == "" {
+		excludes, err := dispatcher.gitExcludes(
+			"git_show",
+			"show", "--name-only", "-z", "--no-renames", "--format=", revision,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return dispatcher.gitOutput(
+			append([]string{
+				"show", "--no-color", "--no-ext-diff", "--no-textconv",
+				"--stat", "--patch", revision, "--", ".",
+			}, excludes...)...,
+		)
+	}
+
+	path, err := dispatcher.gitPathspec(args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// ./ makes the path relative to the workspace rather than the root of
+	// the repository
+	return dispatcher.gitOutput("show", "--no-textconv", revision+":./"+path)
+}
+
+// gitPathspec returns the path relative to the workspace, which is the
+// root of the repository.
+func (dispatcher *Dispatcher) gitPathspec(path string) (string, error) {
+	abs, err := dispatcher.sandbox(gitPath(path))
+	if err != nil {
+		return "", err
+	}
+
+	name, err := filepath.Rel(dispatcher.cwd, abs)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(name), nil
+}
+
+// checkGitRef rejects refs that git would take for options, and paths
+// like HEAD:secret.env that would bypass the path rules of the policy.
+func checkGitRef(ref string) error {
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid ref %q", ref)
+	}
+
+	if strings.Contains(ref, ":") {
+		return fmt.Errorf("invalid ref %q, use the path argument for files", ref)
+	}
+
+	return nil
+}
+
+// gitExcludes runs the git command listing changed paths separated by NUL
+// and returns pathspecs excluding the ones denied by the policy for the
+// tool, so their changes are not shown.
+func (dispatcher *Dispatcher) gitExcludes(tool string, args ...string) ([]string, error) {
+	if dispatcher.policy == nil {
+		return nil, nil
+	}
+
+	err := dispatcher.checkGitRepository()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := dispatcher.git(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	status := args[0] == "status"
+
+	excludes := []string{}
+	entries := strings.Split(output, "\x00")
+	for i := 0; i < len(entries); i++ {
+		name := entries[i]
+		if name == "" {
+			continue
+		}
+
+		if status {
+			// XY path, renames and copies are followed by the original path
+			if len(name) > 3 && (name[0] == 'R' || name[0] == 'C') && i+1 < len(entries) {
+				i++
+				if dispatcher.policy.Check(tool, []string{entries[i]}) != nil {
+					excludes = append(excludes, ":(exclude,literal)"+entries[i])
+				}
+			}
+
+			name = name[min(3, len(name)):]
+		}
+
+		if dispatcher.policy.Check(tool, []string{name}) != nil {
+			excludes = append(excludes, ":(exclude,literal)"+name)
+		}
+	}
+
+	return excludes, nil
+}
+
+// checkGitRepository returns an error if the workspace is not a
+// repository, git diff outside of a repository compares paths instead of
+// failing.
+func (dispatcher *Dispatcher) checkGitRepository() error {
+	_, err := dispatcher.git(nil, "rev-parse", "--git-dir")
+
+	return err
+}
+
+func (dispatcher *Dispatcher) gitOutput(args ...string) (any, error) {
+	err := dispatcher.checkGitRepository()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := dispatcher.git(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := GitResult{}
+	result.Output, result.Truncated = truncateMiddle(output, maxReadOutput)
+
+	return result, nil
+}
+
+// git runs git in the workspace, which has to be the root of its
+// repository, so a workspace inside of another repository doesn't expose
+// the rest of it. Config of the repository that runs commands, like the
+// fsmonitor and hooks, is disabled.
+func (dispatcher *Dispatcher) git(env []string, args ...string) (string, error) {
+	cmd := exec.Command(
+		"git",
+		append([]string{
+			"--no-pager",
+			"-c", "core.quotepath=off",
+			"-c", "core.fsmonitor=",
+			"-c", "core.hooksPath=/dev/null",
+		}, args...)...,
+	)
+	cmd.Dir = dispatcher.cwd
+
+	// variables pointing git to another repository or index are dropped,
+	// identities are kept for commits
+	cmd.Env = []string{}
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, "GIT_") &&
+			!strings.HasPrefix(variable, "GIT_AUTHOR_") &&
+			!strings.HasPrefix(variable, "GIT_COMMITTER_") {
+			continue
+		}
+
+		cmd.Env = append(cmd.Env, variable)
+	}
+
+	cmd.Env = append(
+		cmd.Env,
+		"GIT_CEILING_DIRECTORIES="+filepath.Dir(dispatcher.cwd),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_OPTIONAL_LOCKS=0",
+	)
+	cmd.Env = append(cmd.Env, env...)
+
+	stdout, stderr, err := executil.Run(cmd)
+	if err != nil {
+		message := strings.TrimSpace(string(stderr))
+		if message == "" {
+			message = err.Error()
+		}
+
+		return "", fmt.Errorf("git %s: %s", args[0], message)
+	}
+
+	return string(stdout), nil
+}
+
+// checkAutoCommit makes sure the branch of the thread is a valid ref, thread
+// names like a..b or x.lock are not.
+func (dispatcher *Dispatcher) checkAutoCommit() error {
+	branch := gitBranchPrefix + dispatcher.threadName
+
+	_, err := dispatcher.git(nil, "check-ref-format", "refs/heads/"+branch)
+	if err != nil {
+		return fmt.Errorf(
+			"thread %q can't be auto-committed, %s is not a valid git branch name",
+			dispatcher.threadName,
+			branch,
+		)
+	}
+
+	return nil
+}
+
+// commitTurn commits the workspace to the aight/<thread> branch if the turn
+// called tools that change it. The branch starts from HEAD, the index and
+// HEAD of the repository stay as they are.
+func (dispatcher *Dispatcher) commitTurn(turn int, message Message) error {
+	changed := false
+	for _, content := range message.Content {
+		if content.ToolUse != nil && dispatcher.mutating[content.ToolUse.Name] {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	branch := gitBranchPrefix + dispatcher.threadName
+
+	// the expected old value of the branch, empty means it doesn't exist
+	old := ""
+
+	parent, err := dispatcher.git(nil, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch+"^{commit}")
+	if err == nil {
+		old = strings.TrimSpace(parent)
+	} else {
+		// an unborn HEAD is fine, the branch starts with a root commit
+		parent, _ = dispatcher.git(nil, "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	}
+
+	parent = strings.TrimSpace(parent)
+
+	dir, err := os.MkdirTemp("", "aight-index-")
+	if err != nil {
+		return karma.Format(err, "create temporary directory")
+	}
+
+	defer os.RemoveAll(dir)
+
+	index := []string{"GIT_INDEX_FILE=" + filepath.Join(dir, "index")}
+
+	if parent != "" {
+		_, err = dispatcher.git(index, "read-tree", parent)
+	} else {
+		_, err = dispatcher.git(index, "read-tree", "--empty")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = dispatcher.git(index, "add", "--all", "--", ".", ":(exclude)"+stateDir)
+	if err != nil {
+		return err
+	}
+
+	tree, err := dispatcher.git(index, "write-tree")
+	if err != nil {
+		return err
+	}
+
+	tree = strings.TrimSpace(tree)
+
+	if parent != "" {
+		previous, err := dispatcher.git(nil, "rev-parse", parent+"^{tree}")
+		if err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(previous) == tree {
+			return nil
+		}
+	}
+
+	subject, body := turnCommitMessage(message)
+
+	args := []string{"commit-tree", tree, "-m", subject, "-m", fmt.Sprintf(
+		"%s\nThread: %s\nTurn: %d", body, dispatcher.threadName, turn,
+	)}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+
+	// commits are made even if the repository has no identity configured
+	identity := []string{}
+	if _, err := dispatcher.git(nil, "var", "GIT_COMMITTER_IDENT"); err != nil {
+		identity = []string{
+			"GIT_AUTHOR_NAME=aight", "GIT_AUTHOR_EMAIL=aight@localhost",
+			"GIT_COMMITTER_NAME=aight", "GIT_COMMITTER_EMAIL=aight@localhost",
+		}
+	}
+
+	commit, err := dispatcher.git(identity, args...)
+	if err != nil {
+		return err
+	}
+
+	commit = strings.TrimSpace(commit)
+
+	_, err = dispatcher.git(
+		nil,
+		"update-ref", "-m", fmt.Sprintf("aight: turn %d", turn),
+		"refs/heads/"+branch, commit, old,
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"{%s} committed %s to %s: %s",
+		color.GreenString("git"),
+		commit[:min(len(commit), 7)],
+		branch,
+		subject,
+	)
+
+	return nil
+}
+
+// turnCommitMessage returns the subject from the first line of the text of
+// the message, or the called tools if there is no text, and the body
+// listing the tool calls.
+func turnCommitMessage(message Message) (string, string) {
+	subject := ""
+	tools := []string{}
+	body := strings.Builder{}
+
+	for _, content := range message.Content {
+		switch {
+		case content.Type == ContentTypeText && subject == "":
+			for _, line := range strings.Split(content.Text, "\n") {
+				line = strings.TrimSpace(line)
+				if line != "" {
+					subject = line
+					break
+				}
+			}
+
+		case content.ToolUse != nil:
+			tools = append(tools, content.ToolUse.Name)
+
+			input := string(content.ToolUse.Input)
+			if len(input) > gitMaxToolInput {
+				input = strings.ToValidUTF8(input[:gitMaxToolInput], "") + "..."
+			}
+
+			fmt.Fprintf(&body, "- %s %s\n", content.ToolUse.Name, input)
+		}
+	}
+
+	if subject == "" {
+		subject = "Run " + strings.Join(tools, ", ")
+	}
+
+	if utf8.RuneCountInString(subject) > gitMaxSubject {
+		subject = string([]rune(subject)[:gitMaxSubject-3]) + "..."
+	}
+
+	return subject, body.String()
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// initGitRepo makes the workspace a repository with the files committed.
+func initGitRepo(t *testing.T, dispatcher *Dispatcher, files map[string]string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	writeFiles(t, dispatcher.cwd, files)
+
+	commands := [][]string{
+		{"init", "--quiet", "--initial-branch=master"},
+		{"config", "user.name", "Tester"},
+		{"config", "user.email", "tester@example.com"},
+		{"add", "--all"},
+		{"commit", "--quiet", "--message", "Initial commit"},
+	}
+	for _, command := range commands {
+		_, err := dispatcher.git(nil, command...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func gitOutput(t *testing.T, dispatcher *Dispatcher, args ...string) string {
+	t.Helper()
+
+	output, err := dispatcher.git(nil, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return output
+}
+
+func TestDispatcher_GitTools(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	initGitRepo(t, dispatcher, map[string]string{
+		"main.go":    "package main\n",
+		"lib/lib.go": "package lib\n",
+	})
+
+	writeFiles(t, dispatcher.cwd, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+		"new.txt": "new\n",
+	})
+
+	result, err := dispatcher.gitStatus(GitStatusArguments{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := result.(GitResult).Output; status != "## master\n M main.go\n?? new.txt\n" {
+		t.Errorf("unexpected status:\n%s", status)
+	}
+
+	result, err = dispatcher.gitDiff(GitDiffArguments{Path: "main.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := result.(GitResult).Output; !strings.Contains(diff, "+func main() {}\n") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+
+	result, err = dispatcher.gitDiff(GitDiffArguments{Staged: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := result.(GitResult).Output; diff != "" {
+		t.Errorf("expected no staged changes, got:\n%s", diff)
+	}
+
+	result, err = dispatcher.gitLog(GitLogArguments{Path: "lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if log := result.(GitResult).Output; !strings.HasSuffix(log, " Tester Initial commit\n") {
+		t.Errorf("unexpected log:\n%s", log)
+	}
+
+	result, err = dispatcher.gitShow(GitShowArguments{Path: "lib/lib.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if show := result.(GitResult).Output; show != "package lib\n" {
+		t.Errorf("unexpected file at HEAD:\n%s", show)
+	}
+
+	result, err = dispatcher.gitShow(GitShowArguments{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if show := result.(GitResult).Output; !strings.Contains(show, "    Initial commit\n") ||
+		!strings.Contains(show, "+package lib\n") {
+		t.Errorf("unexpected commit:\n%s", show)
+	}
+
+	_, err = dispatcher.gitLog(GitLogArguments{Ref: "--output=/tmp/log"})
+	if err == nil || !strings.Contains(err.Error(), "invalid ref") {
+		t.Errorf("expected refs looking like options to be rejected, got %v", err)
+	}
+
+	_, err = dispatcher.gitDiff(GitDiffArguments{Path: "../"})
+	if err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("expected paths outside of the workspace to be rejected, got %v", err)
+	}
+
+	// a workspace inside of a repository is not the root of it
+	nested := NewDispatcher(filepath.Join(dispatcher.cwd, "lib"), "model", false, nil)
+
+	_, err = nested.gitStatus(GitStatusArguments{})
+	if err == nil || !strings.Contains(err.Error(), "not a git repository") {
+		t.Errorf("expected the parent repository to be ignored, got %v", err)
+	}
+}
+
+func TestRun_AutoCommit(t *testing.T) {
+	dispatcher := runScriptWith(
+		t,
+		filepath.Join("testdata", "tools.yaml"),
+		func(dispatcher *Dispatcher) {
+			initGitRepo(t, dispatcher, map[string]string{
+				".gitignore": "*.db\n",
+				"README.md":  "readme\n",
+			})
+
+			dispatcher.autoCommit = true
+		},
+		"do it",
+	)
+
+	// turns that changed nothing or only ignored files are not committed
+	log := gitOutput(t, dispatcher, "log", "--format=%s", "aight/default")
+	if log != "Run fs_move, fs_remove\nRun fs_edit\nRun fs_patch\nCreating files.\nInitial commit\n" {
+		t.Errorf("unexpected log of the branch:\n%s", log)
+	}
+
+	body := gitOutput(t, dispatcher, "log", "-1", "--format=%b", "aight/default~3")
+	if !strings.Contains(body, `- fs_write {"contents":"hello\n","path":"notes.txt"}`) ||
+		!strings.Contains(body, "Thread: default\nTurn: 2\n") {
+		t.Errorf("unexpected body of the first turn:\n%s", body)
+	}
+
+	files := gitOutput(t, dispatcher, "ls-tree", "-r", "--name-only", "aight/default")
+	if files != ".gitignore\nREADME.md\nrenamed.txt\n" {
+		t.Errorf("unexpected files of the branch:\n%s", files)
+	}
+
+	// HEAD and the index of the repository are left as they are
+	if head := gitOutput(t, dispatcher, "log", "--format=%s"); head != "Initial commit\n" {
+		t.Errorf("unexpected log of HEAD:\n%s", head)
+	}
+
+	if staged := gitOutput(t, dispatcher, "diff", "--cached", "--name-only"); staged != "" {
+		t.Errorf("expected nothing staged, got:\n%s", staged)
+	}
+}
+
+func TestDispatcher_GitPolicy(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	initGitRepo(t, dispatcher, map[string]string{
+		"main.go":    "package main\n",
+		"secret.env": "TOKEN=committed\n",
+	})
+
+	var err error
+	dispatcher.policy, err = NewPolicy(dispatcher.cwd, []PolicyRule{
+		{Action: PolicyDeny, Tool: "*", Paths: []string{"*.env"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dispatcher.cwd, map[string]string{
+		"main.go":    "package main\n\nfunc main() {}\n",
+		"secret.env": "TOKEN=changed\n",
+	})
+
+	outputs := map[string]func() (any, error){
+		"status": func() (any, error) { return dispatcher.gitStatus(GitStatusArguments{}) },
+		"diff":   func() (any, error) { return dispatcher.gitDiff(GitDiffArguments{}) },
+		"diff against HEAD": func() (any, error) {
+			return dispatcher.gitDiff(GitDiffArguments{Ref: "HEAD"})
+		},
+		"show": func() (any, error) { return dispatcher.gitShow(GitShowArguments{}) },
+	}
+	for name, run := range outputs {
+		result, err := run()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		output := result.(GitResult).Output
+		if strings.Contains(output, "secret.env") || strings.Contains(output, "TOKEN") {
+			t.Errorf("%s: denied file is shown:\n%s", name, output)
+		}
+
+		if !strings.Contains(output, "main.go") {
+			t.Errorf("%s: allowed file is not shown:\n%s", name, output)
+		}
+	}
+
+	for _, revision := range []string{"HEAD:secret.env", "HEAD:./secret.env", "HEAD^{tree}"} {
+		_, err := dispatcher.gitShow(GitShowArguments{Revision: revision})
+		if err == nil {
+			t.Errorf("%s: expected the revision to be rejected", revision)
+		}
+	}
+
+	// config of the repository can't run commands from read tools, and it
+	// can't be written by tools
+	gitOutput(t, dispatcher, "config", "core.fsmonitor", "touch fsmonitor-ran; false")
+
+	_, err = dispatcher.gitStatus(GitStatusArguments{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dispatcher.cwd, "fsmonitor-ran")); !os.IsNotExist(err) {
+		t.Errorf("fsmonitor of the repository must not run, got %v", err)
+	}
+
+	_, err = dispatcher.writeFile(WriteFileArguments{Path: ".git/config", Contents: "[core]\n"})
+	if err == nil || !strings.Contains(err.Error(), ".git directory") {
+		t.Errorf("expected writes to .git to be rejected, got %v", err)
+	}
+
+	err = os.Symlink(".git", filepath.Join(dispatcher.cwd, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dispatcher.editFile(EditFileArguments{Path: "link/config", OldText: "[core]", NewText: "[x]"})
+	if err == nil || !strings.Contains(err.Error(), ".git directory") {
+		t.Errorf("expected writes through symlinks to .git to be rejected, got %v", err)
+	}
+}
+
+func TestDispatcher_CheckAutoCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	tests := map[string]bool{
+		"default":   true,
+		"fix-1.2_x": true,
+		"a..b":      false,
+		"x.lock":    false,
+		"trailing.": false,
+		".hidden":   false,
+	}
+	for name, valid := range tests {
+		dispatcher.threadName = name
+
+		err := dispatcher.checkAutoCommit()
+		if (err == nil) != valid {
+			t.Errorf("%s: expected valid %v, got %v", name, valid, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// matchGlob reports whether the slash-separated relative path matches the
+// pattern. Besides path.Match syntax "**" matches any number of
+// directories, and a pattern without a slash matches the base name in any
+// directory, like in .gitignore.
+func matchGlob(pattern string, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+		return matched
+	}
+
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchGlobs reports whether the path matches none of the exclude globs and
+// one of the include globs, if there are any.
+func matchGlobs(name string, include []string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if matchGlob(pattern, name) {
+			return false
+		}
+	}
+
+	if len(include) == 0 {
+		return true
+	}
+
+	for _, pattern := range include {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkGlob returns an error if the pattern is malformed.
+func checkGlob(pattern string) error {
+	_, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), "")
+	return err
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+const (
+	globDefaultResults = 100
+	globMaxResults     = 1000
+)
+
+type GlobArguments struct {
+	Pattern    string `json:"pattern"`
+	Path       string `json:"path,omitempty"`
+	MaxResults int    `json:"max_results,omitempty"`
+}
+
+func (args GlobArguments) Paths() []string {
+	if args.Path == "" {
+		return []string{"."}
+	}
+
+	return []string{args.Path}
+}
+
+type GlobFile struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+
+	modified time.Time
+}
+
+type GlobResult struct {
+	Files     []GlobFile `json:"files"`
+	Count     int        `json:"count"`
+	Truncated bool       `json:"truncated,omitempty"`
+}
+
+// glob finds files matching the pattern relative to the path, the most
+// recently modified first.
+func (dispatcher *Dispatcher) glob(args GlobArguments) (any, error) {
+	if args.Pattern == "" {
+		return nil, errors.New("pattern is empty")
+	}
+
+	err := checkGlob(args.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %s", args.Pattern, err)
+	}
+
+	limit := args.MaxResults
+	if limit <= 0 {
+		limit = globDefaultResults
+	}
+
+	limit = min(limit, globMaxResults)
+
+	root, err := dispatcher.sandbox(args.Paths()[0])
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := filepath.Rel(dispatcher.cwd, root)
+	if err != nil {
+		return nil, err
+	}
+
+	start = filepath.ToSlash(start)
+
+	result := GlobResult{Files: []GlobFile{}}
+
+	err = dispatcher.walkWorkspace(root, func(name string, entry fs.DirEntry) error {
+		if entry.IsDir() {
+			return nil
+		}
+
+		relative := name
+		if start != "." {
+			relative = strings.TrimPrefix(name, start+"/")
+		}
+
+		if !matchGlob(args.Pattern, relative) {
+			return nil
+		}
+
+		if dispatcher.policy.Check("fs_glob", []string{name}) != nil {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		result.Files = append(result.Files, GlobFile{
+			Path:     name,
+			Size:     info.Size(),
+			Modified: info.ModTime().UTC().Format(time.RFC3339),
+			modified: info.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result.Files, func(i, j int) bool {
+		return result.Files[i].modified.After(result.Files[j].modified)
+	})
+
+	result.Count = len(result.Files)
+	if len(result.Files) > limit {
+		result.Files = result.Files[:limit]
+		result.Truncated = true
+	}
+
+	return result, nil
+}
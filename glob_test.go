@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDispatcher_Glob(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	writeFiles(t, dispatcher.cwd, map[string]string{
+		".gitignore":             "vendor/\n",
+		"main.go":                "package main\n",
+		"main_test.go":           "package main\n",
+		"cmd/tool/main.go":       "package main\n",
+		"cmd/tool/tool_test.go":  "package main\n",
+		"vendor/lib/lib_test.go": "package lib\n",
+	})
+
+	// the oldest file goes last
+	past := time.Now().Add(-time.Hour)
+
+	err := os.Chtimes(filepath.Join(dispatcher.cwd, "main_test.go"), past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args     GlobArguments
+		expected []string
+	}{
+		{GlobArguments{Pattern: "**/*_test.go"}, []string{"cmd/tool/tool_test.go", "main_test.go"}},
+		{GlobArguments{Pattern: "*/main.go", Path: "cmd"}, []string{"cmd/tool/main.go"}},
+		{GlobArguments{Pattern: "*.md"}, []string{}},
+	}
+	for _, test := range tests {
+		result, err := dispatcher.glob(test.args)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", test.args, err)
+			continue
+		}
+
+		paths := []string{}
+		for _, file := range result.(GlobResult).Files {
+			paths = append(paths, file.Path)
+		}
+
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.args, test.expected, paths)
+		}
+	}
+
+	result, err := dispatcher.glob(GlobArguments{Pattern: "*.go", MaxResults: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if glob := result.(GlobResult); len(glob.Files) != 1 || glob.Count != 4 || !glob.Truncated ||
+		glob.Files[0].Size != int64(len("package main\n")) {
+		t.Errorf("expected the result to be truncated, got %+v", glob)
+	}
+}
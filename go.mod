@@ -11,6 +11,8 @@ require (
 	github.com/reconquest/executil-go v0.0.0-20181110204642-1f5c2d67813f
 	github.com/reconquest/karma-go v1.3.1
 	go.uber.org/ratelimit v0.3.0
+	golang.org/x/sys v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -24,6 +26,4 @@ require (
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
 	golang.org/x/crypto v0.15.0 // indirect
-	golang.org/x/sys v0.14.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
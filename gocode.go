@@ -0,0 +1,774 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// goMaxReferences caps the number of references returned by go_references.
+const goMaxReferences = 200
+
+type GoSymbolsArguments struct {
+	// Path is the directory of the package relative to the workspace
+	Path     string `json:"path"`
+	Exported bool   `json:"exported,omitempty"`
+	Tests    bool   `json:"tests,omitempty"`
+}
+
+func (args GoSymbolsArguments) Paths() []string {
+	return []string{args.Path}
+}
+
+type GoSymbolArguments struct {
+	// Path is the directory of the package relative to the workspace
+	Path string `json:"path"`
+
+	// Symbol is a package-level name or Type.Method or Type.Field
+	Symbol string `json:"symbol"`
+}
+
+func (args GoSymbolArguments) Paths() []string {
+	return []string{args.Path}
+}
+
+type GoSymbolsResult struct {
+	Package string `json:"package"`
+	Symbols string `json:"symbols"`
+}
+
+type GoDefinition struct {
+	Kind      string `json:"kind"`
+	Position  string `json:"position"`
+	Signature string `json:"signature"`
+	Doc       string `json:"doc,omitempty"`
+}
+
+type GoReferencesResult struct {
+	Definition string `json:"definition"`
+
+	// References are lines in the path:line:column: text format
+	References string `json:"references"`
+	Count      int    `json:"count"`
+	Truncated  bool   `json:"truncated,omitempty"`
+}
+
+type GoSource struct {
+	Position string `json:"position"`
+	Source   string `json:"source"`
+}
+
+// goWorkspace type-checks packages of the workspace from source. Packages
+// of the module are imported from the workspace, other ones are imported
+// as empty, so expressions using them are left untyped.
+type goWorkspace struct {
+	dispatcher *Dispatcher
+	tool       string
+
+	// module is the module path of go.mod of the workspace
+	module string
+
+	fset     *token.FileSet
+	packages map[string]*goPackage
+	external map[string]*types.Package
+}
+
+type goPackage struct {
+	dir   string
+	files []*ast.File
+	types *types.Package
+	info  *types.Info
+
+	// sources are contents of files by their slash-separated paths
+	sources map[string][]byte
+}
+
+func (dispatcher *Dispatcher) newGoWorkspace(tool string) *goWorkspace {
+	workspace := &goWorkspace{
+		dispatcher: dispatcher,
+		tool:       tool,
+		fset:       token.NewFileSet(),
+		packages:   map[string]*goPackage{},
+		external:   map[string]*types.Package{},
+	}
+
+	contents, err := dispatcher.readWorkspaceFile("go.mod")
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "module" {
+				workspace.module = strings.Trim(fields[1], `"`)
+				break
+			}
+		}
+	}
+
+	return workspace
+}
+
+func (workspace *goWorkspace) Import(path string) (*types.Package, error) {
+	return workspace.ImportFrom(path, "", 0)
+}
+
+func (workspace *goWorkspace) ImportFrom(path string, _ string, _ types.ImportMode) (*types.Package, error) {
+	if workspace.module != "" &&
+		(path == workspace.module || strings.HasPrefix(path, workspace.module+"/")) {
+		dir := strings.TrimPrefix(strings.TrimPrefix(path, workspace.module), "/")
+		if dir == "" {
+			dir = "."
+		}
+
+		pkg, err := workspace.load(dir, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return pkg.types, nil
+	}
+
+	if pkg, ok := workspace.external[path]; ok {
+		return pkg, nil
+	}
+
+	name := path[strings.LastIndex(path, "/")+1:]
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "go-"), "-go")
+
+	pkg := types.NewPackage(path, name)
+	pkg.MarkComplete()
+
+	workspace.external[path] = pkg
+
+	return pkg, nil
+}
+
+// load parses and type-checks the package of the directory, with tests
+// files of the package itself, but not of the _test package.
+func (workspace *goWorkspace) load(dir string, tests bool) (*goPackage, error) {
+	dir = path.Clean(filepath.ToSlash(dir))
+
+	key := dir
+	if tests {
+		key += " tests"
+	}
+
+	if pkg, ok := workspace.packages[key]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", dir)
+		}
+
+		return pkg, nil
+	}
+
+	workspace.packages[key] = nil
+
+	pkg, err := workspace.parse(dir, tests)
+	if err != nil {
+		delete(workspace.packages, key)
+		return nil, err
+	}
+
+	config := types.Config{
+		Importer:    workspace,
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+
+	pkg.info = &types.Info{
+		Defs: map[*ast.Ident]types.Object{},
+		Uses: map[*ast.Ident]types.Object{},
+	}
+
+	pkg.types, _ = config.Check(dir, workspace.fset, pkg.files, pkg.info)
+
+	workspace.packages[key] = pkg
+
+	return pkg, nil
+}
+
+func (workspace *goWorkspace) parse(dir string, tests bool) (*goPackage, error) {
+	root, err := workspace.dispatcher.sandbox(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, karma.Format(err, "read dir: %s", dir)
+	}
+
+	pkg := &goPackage{dir: dir, sources: map[string][]byte{}}
+
+	name := ""
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") ||
+			!tests && strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		// files for other platforms would redeclare names
+		matched, err := build.Default.MatchFile(root, entry.Name())
+		if err != nil || !matched {
+			continue
+		}
+
+		filename := path.Join(dir, entry.Name())
+		if workspace.dispatcher.policy.Check(workspace.tool, []string{filename}) != nil {
+			continue
+		}
+
+		contents, err := workspace.dispatcher.readWorkspaceFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := parser.ParseFile(workspace.fset, filename, contents, parser.ParseComments)
+		if file == nil {
+			return nil, karma.Format(err, "parse file: %s", filename)
+		}
+
+		// the _test package is a separate one
+		if strings.HasSuffix(file.Name.Name, "_test") && name != file.Name.Name {
+			continue
+		}
+
+		if name == "" {
+			name = file.Name.Name
+		}
+
+		if file.Name.Name != name {
+			continue
+		}
+
+		pkg.files = append(pkg.files, file)
+		pkg.sources[filename] = contents
+	}
+
+	if len(pkg.files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+
+	return pkg, nil
+}
+
+// lookup finds the object of the symbol: a package-level name, a method or
+// a field of a package-level type.
+func (pkg *goPackage) lookup(symbol string) (types.Object, error) {
+	name, member, _ := strings.Cut(symbol, ".")
+
+	object := pkg.types.Scope().Lookup(name)
+	if object == nil {
+		return nil, fmt.Errorf(
+			"%s is not declared in package %s, use go_symbols to list declarations",
+			name, pkg.dir,
+		)
+	}
+
+	if member == "" {
+		return object, nil
+	}
+
+	if _, ok := object.(*types.TypeName); !ok {
+		return nil, fmt.Errorf("%s is not a type", name)
+	}
+
+	selected, _, _ := types.LookupFieldOrMethod(object.Type(), true, pkg.types, member)
+	if selected == nil {
+		return nil, fmt.Errorf("%s has no field or method %s", name, member)
+	}
+
+	return selected, nil
+}
+
+func (workspace *goWorkspace) position(pos token.Pos) string {
+	position := workspace.fset.Position(pos)
+
+	return fmt.Sprintf("%s:%d:%d", position.Filename, position.Line, position.Column)
+}
+
+// declaration returns the declaration node of the object with its doc
+// comment, grouped declarations are narrowed to the spec of the object.
+func (pkg *goPackage) declaration(object types.Object) (ast.Node, *ast.CommentGroup) {
+	nodes := pkg.enclosing(object.Pos())
+	for i, node := range nodes {
+		switch node := node.(type) {
+		case *ast.Field:
+			return node, node.Doc
+
+		case *ast.FuncDecl:
+			return node, node.Doc
+
+		case *ast.TypeSpec, *ast.ValueSpec:
+			parent, ok := nodes[i+1].(*ast.GenDecl)
+			if ok && parent.Lparen == token.NoPos {
+				return parent, parent.Doc
+			}
+
+			if spec, ok := node.(*ast.TypeSpec); ok {
+				return spec, spec.Doc
+			}
+
+			return node, node.(*ast.ValueSpec).Doc
+		}
+	}
+
+	return nil, nil
+}
+
+func goKind(object types.Object) string {
+	switch object := object.(type) {
+	case *types.Func:
+		if object.Type().(*types.Signature).Recv() != nil {
+			return "method"
+		}
+
+		return "func"
+	case *types.TypeName:
+		return "type"
+	case *types.Const:
+		return "const"
+	case *types.Var:
+		if object.IsField() {
+			return "field"
+		}
+
+		return "var"
+	}
+
+	return "object"
+}
+
+// signature describes the object as it's written in the source, types are
+// shown with their kind only since their fields and methods are listed
+// separately. Types of other modules are not loaded, so go/types would
+// show them as invalid.
+func (pkg *goPackage) signature(fset *token.FileSet, object types.Object) string {
+	print := func(node any) string {
+		var buffer bytes.Buffer
+
+		err := printer.Fprint(&buffer, fset, node)
+		if err != nil {
+			return ""
+		}
+
+		return buffer.String()
+	}
+
+	for _, node := range pkg.enclosing(object.Pos()) {
+		switch node := node.(type) {
+		case *ast.Field:
+			if function, ok := node.Type.(*ast.FuncType); ok {
+				return "func " + object.Name() + strings.TrimPrefix(print(function), "func")
+			}
+
+			return "field " + object.Name() + " " + print(node.Type)
+
+		case *ast.FuncDecl:
+			declaration := *node
+			declaration.Doc = nil
+			declaration.Body = nil
+
+			return print(&declaration)
+
+		case *ast.TypeSpec:
+			signature := "type " + object.Name()
+			if node.TypeParams != nil {
+				signature += "[" + strings.TrimSuffix(strings.TrimPrefix(print(node.TypeParams), "("), ")") + "]"
+			}
+
+			switch node.Type.(type) {
+			case *ast.StructType:
+				return signature + " struct"
+			case *ast.InterfaceType:
+				return signature + " interface"
+			}
+
+			if node.Assign != token.NoPos {
+				signature += " ="
+			}
+
+			return signature + " " + print(node.Type)
+
+		case *ast.ValueSpec:
+			signature := goKind(object) + " " + object.Name()
+			if node.Type != nil {
+				signature += " " + print(node.Type)
+			}
+
+			if _, ok := object.(*types.Const); ok {
+				for i, name := range node.Names {
+					if name.Pos() == object.Pos() && i < len(node.Values) {
+						signature += " = " + print(node.Values[i])
+					}
+				}
+			}
+
+			return signature
+		}
+	}
+
+	return types.ObjectString(object, types.RelativeTo(pkg.types))
+}
+
+// enclosing returns nodes containing the position, the innermost first.
+func (pkg *goPackage) enclosing(pos token.Pos) []ast.Node {
+	var nodes []ast.Node
+	for _, file := range pkg.files {
+		if pos < file.Pos() || pos >= file.End() {
+			continue
+		}
+
+		ast.Inspect(file, func(node ast.Node) bool {
+			if node == nil || pos < node.Pos() || pos >= node.End() {
+				return false
+			}
+
+			nodes = append([]ast.Node{node}, nodes...)
+
+			return true
+		})
+	}
+
+	return nodes
+}
+
+func (dispatcher *Dispatcher) goSymbols(args GoSymbolsArguments) (any, error) {
+	workspace := dispatcher.newGoWorkspace("go_symbols")
+
+	pkg, err := workspace.load(args.Path, args.Tests)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []types.Object{}
+
+	scope := pkg.types.Scope()
+	for _, name := range scope.Names() {
+		object := scope.Lookup(name)
+		objects = append(objects, object)
+
+		named, ok := object.Type().(*types.Named)
+		if _, isType := object.(*types.TypeName); !ok || !isType {
+			continue
+		}
+
+		for i := 0; i < named.NumMethods(); i++ {
+			objects = append(objects, named.Method(i))
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		a := workspace.fset.Position(objects[i].Pos())
+		b := workspace.fset.Position(objects[j].Pos())
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+
+		return a.Offset < b.Offset
+	})
+
+	var buffer strings.Builder
+	for _, object := range objects {
+		if args.Exported && !object.Exported() {
+			continue
+		}
+
+		position := workspace.fset.Position(object.Pos())
+
+		fmt.Fprintf(
+			&buffer,
+			"%s:%d %s\n",
+			position.Filename, position.Line, pkg.signature(workspace.fset, object),
+		)
+	}
+
+	return GoSymbolsResult{
+		Package: pkg.types.Name(),
+		Symbols: buffer.String(),
+	}, nil
+}
+
+func (dispatcher *Dispatcher) goDefinition(args GoSymbolArguments) (any, error) {
+	workspace := dispatcher.newGoWorkspace("go_definition")
+
+	pkg, err := workspace.load(args.Path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := pkg.lookup(args.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	definition := GoDefinition{
+		Kind:      goKind(object),
+		Position:  workspace.position(object.Pos()),
+		Signature: pkg.signature(workspace.fset, object),
+	}
+
+	_, doc := pkg.declaration(object)
+	if doc != nil {
+		definition.Doc = doc.Text()
+	}
+
+	return definition, nil
+}
+
+func (dispatcher *Dispatcher) goSource(args GoSymbolArguments) (any, error) {
+	workspace := dispatcher.newGoWorkspace("go_source")
+
+	pkg, err := workspace.load(args.Path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := pkg.lookup(args.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	node, doc := pkg.declaration(object)
+	if node == nil {
+		return nil, fmt.Errorf("declaration of %s is not found", args.Symbol)
+	}
+
+	start := node.Pos()
+	if doc != nil {
+		start = doc.Pos()
+	}
+
+	from := workspace.fset.Position(start)
+	to := workspace.fset.Position(node.End())
+
+	source := string(pkg.sources[from.Filename][from.Offset:to.Offset])
+	if len(source) > maxReadOutput {
+		return nil, fmt.Errorf(
+			"source of %s is too large, read %s:%d-%d with fs_read",
+			args.Symbol, from.Filename, from.Line, to.Line,
+		)
+	}
+
+	return GoSource{
+		Position: fmt.Sprintf("%s:%d", from.Filename, from.Line),
+		Source:   source + "\n",
+	}, nil
+}
+
+func (dispatcher *Dispatcher) goReferences(args GoSymbolArguments) (any, error) {
+	workspace := dispatcher.newGoWorkspace("go_references")
+
+	pkg, err := workspace.load(args.Path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := pkg.lookup(args.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// the same file may be parsed more than once, so objects are compared
+	// by the position of the declaration
+	key := func(object types.Object) token.Position {
+		position := workspace.fset.Position(object.Pos())
+		position.Line, position.Column = 0, 0
+
+		return position
+	}
+
+	target := key(object)
+
+	result := GoReferencesResult{Definition: workspace.position(object.Pos())}
+
+	dirs, err := dispatcher.goPackageDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	references := []token.Position{}
+	lines := map[string][]string{}
+
+	for _, dir := range dirs {
+		// the package with tests and its _test package
+		packages := []*goPackage{}
+
+		tested, err := workspace.load(dir, true)
+		if err != nil {
+			continue
+		}
+
+		packages = append(packages, tested)
+
+		external, err := workspace.loadExternalTests(dir, tested.types.Name()+"_test")
+		if err == nil && external != nil {
+			packages = append(packages, external)
+		}
+
+		for _, current := range packages {
+			for ident, used := range current.info.Uses {
+				if used.Pos() == token.NoPos || key(used) != target {
+					continue
+				}
+
+				position := workspace.fset.Position(ident.Pos())
+
+				id := position.String()
+				if seen[id] {
+					continue
+				}
+
+				seen[id] = true
+
+				references = append(references, position)
+
+				if _, ok := lines[position.Filename]; !ok {
+					lines[position.Filename] = strings.Split(string(current.sources[position.Filename]), "\n")
+				}
+			}
+		}
+	}
+
+	sort.Slice(references, func(i, j int) bool {
+		if references[i].Filename != references[j].Filename {
+			return references[i].Filename < references[j].Filename
+		}
+
+		return references[i].Offset < references[j].Offset
+	})
+
+	result.Count = len(references)
+	if len(references) > goMaxReferences {
+		references = references[:goMaxReferences]
+		result.Truncated = true
+	}
+
+	var buffer strings.Builder
+	for _, position := range references {
+		fmt.Fprintf(
+			&buffer,
+			"%s:%d:%d: %s\n",
+			position.Filename, position.Line, position.Column,
+			grepLine(strings.TrimSpace(lines[position.Filename][position.Line-1])),
+		)
+	}
+
+	result.References = buffer.String()
+
+	return result, nil
+}
+
+// loadExternalTests type-checks the _test package of the directory, nil if
+// there is none.
+func (workspace *goWorkspace) loadExternalTests(dir string, name string) (*goPackage, error) {
+	root, err := workspace.dispatcher.sandbox(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &goPackage{dir: dir, sources: map[string][]byte{}}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		matched, err := build.Default.MatchFile(root, entry.Name())
+		if err != nil || !matched {
+			continue
+		}
+
+		filename := path.Join(dir, entry.Name())
+		if workspace.dispatcher.policy.Check(workspace.tool, []string{filename}) != nil {
+			continue
+		}
+
+		contents, err := workspace.dispatcher.readWorkspaceFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		file, _ := parser.ParseFile(workspace.fset, filename, contents, parser.ParseComments)
+		if file == nil || file.Name.Name != name {
+			continue
+		}
+
+		pkg.files = append(pkg.files, file)
+		pkg.sources[filename] = contents
+	}
+
+	if len(pkg.files) == 0 {
+		return nil, nil
+	}
+
+	config := types.Config{
+		Importer:    workspace,
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+
+	pkg.info = &types.Info{
+		Defs: map[*ast.Ident]types.Object{},
+		Uses: map[*ast.Ident]types.Object{},
+	}
+
+	pkg.types, _ = config.Check(dir+"_test", workspace.fset, pkg.files, pkg.info)
+
+	return pkg, nil
+}
+
+// goPackageDirs returns directories of the workspace with Go files, the go
+// tool ignores testdata and directories starting with . or _.
+func (dispatcher *Dispatcher) goPackageDirs() ([]string, error) {
+	dirs := []string{}
+	seen := map[string]bool{}
+
+	err := dispatcher.walkWorkspace(dispatcher.cwd, func(name string, entry fs.DirEntry) error {
+		if entry.IsDir() {
+			base := entry.Name()
+			if name != "." && (base == "testdata" || base == "vendor" ||
+				strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_")) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		dir := path.Dir(name)
+		if strings.HasSuffix(name, ".go") && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dirs) == 0 {
+		return nil, errors.New("there are no Go files in the workspace")
+	}
+
+	return dirs, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDispatcher_GoTools(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	writeFiles(t, dispatcher.cwd, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"main.go": strings.Join([]string{
+			"package main",
+			"",
+			`import "example.com/app/lib"`,
+			"",
+			"func main() {",
+			"\tstore := lib.NewStore()",
+			"\tprintln(store.Get(), lib.Limit)",
+			"}",
+		}, "\n"),
+		"lib/lib.go": strings.Join([]string{
+			"package lib",
+			"",
+			`import "net/http"`,
+			"",
+			"const Limit = 10",
+			"",
+			"// Store keeps values.",
+			"type Store struct {",
+			"\t// Client fetches values.",
+			"\tClient *http.Client",
+			"}",
+			"",
+			"// NewStore creates a store.",
+			"func NewStore() *Store {",
+			"\treturn &Store{}",
+			"}",
+			"",
+			"// Get returns the value.",
+			"func (store *Store) Get() string {",
+			"\treturn \"value\"",
+			"}",
+			"",
+			"func (store *Store) get() int { return Limit }",
+		}, "\n"),
+		"lib/lib_test.go": strings.Join([]string{
+			"package lib_test",
+			"",
+			`import "example.com/app/lib"`,
+			"",
+			"var value = lib.NewStore().Get()",
+		}, "\n"),
+	})
+
+	result, err := dispatcher.goSymbols(GoSymbolsArguments{Path: "lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "lib/lib.go:5 const Limit = 10\n" +
+		"lib/lib.go:8 type Store struct\n" +
+		"lib/lib.go:14 func NewStore() *Store\n" +
+		"lib/lib.go:19 func (store *Store) Get() string\n" +
+		"lib/lib.go:23 func (store *Store) get() int\n"
+	if symbols := result.(GoSymbolsResult).Symbols; symbols != expected {
+		t.Errorf("unexpected symbols:\n%s\nexpected:\n%s", symbols, expected)
+	}
+
+	result, err = dispatcher.goSymbols(GoSymbolsArguments{Path: "lib", Exported: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(result.(GoSymbolsResult).Symbols, "get()") {
+		t.Errorf("expected only exported symbols, got:\n%s", result.(GoSymbolsResult).Symbols)
+	}
+
+	result, err = dispatcher.goDefinition(GoSymbolArguments{Path: "lib", Symbol: "Store.Client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	definition := result.(GoDefinition)
+	if definition != (GoDefinition{
+		Kind:      "field",
+		Position:  "lib/lib.go:10:2",
+		Signature: "field Client *http.Client",
+		Doc:       "Client fetches values.\n",
+	}) {
+		t.Errorf("unexpected definition: %#v", definition)
+	}
+
+	result, err = dispatcher.goReferences(GoSymbolArguments{Path: "lib", Symbol: "Store.Get"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	references := result.(GoReferencesResult)
+	if references.Definition != "lib/lib.go:19:21" || references.References != ""+
+		"lib/lib_test.go:5:28: var value = lib.NewStore().Get()\n"+
+		"main.go:7:16: println(store.Get(), lib.Limit)\n" {
+		t.Errorf("unexpected references: %#v", references)
+	}
+
+	result, err = dispatcher.goSource(GoSymbolArguments{Path: "lib", Symbol: "NewStore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := result.(GoSource)
+	if source.Position != "lib/lib.go:13" ||
+		source.Source != "// NewStore creates a store.\nfunc NewStore() *Store {\n\treturn &Store{}\n}\n" {
+		t.Errorf("unexpected source: %#v", source)
+	}
+
+	_, err = dispatcher.goDefinition(GoSymbolArguments{Path: "lib", Symbol: "Missing"})
+	if err == nil || !strings.Contains(err.Error(), "Missing is not declared in package lib") {
+		t.Errorf("expected error for unknown symbol, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+const (
+	grepDefaultResults = 100
+	grepMaxResults     = 1000
+	grepMaxContext     = 10
+
+	// grepMaxLine is the number of bytes of a line shown, longer lines are
+	// usually minified or generated
+	grepMaxLine = 300
+)
+
+type GrepArguments struct {
+	Pattern string `json:"pattern"`
+	Path    string `json:"path,omitempty"`
+
+	// Include and Exclude are globs of file paths, a glob without a slash
+	// matches the file name
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	IgnoreCase bool `json:"ignore_case,omitempty"`
+
+	// Context is the number of lines shown before and after each match
+	Context int `json:"context,omitempty"`
+
+	MaxResults int `json:"max_results,omitempty"`
+}
+
+func (args GrepArguments) Paths() []string {
+	return []string{args.root()}
+}
+
+func (args GrepArguments) root() string {
+	if args.Path == "" {
+		return "."
+	}
+
+	return args.Path
+}
+
+type GrepResult struct {
+	// Matches are lines in the grep format: path:line:text for matches and
+	// path-line-text for context, groups of lines are separated by --
+	Matches   string `json:"matches"`
+	Count     int    `json:"count"`
+	Files     int    `json:"files"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+func (dispatcher *Dispatcher) grep(args GrepArguments) (any, error) {
+	if args.Pattern == "" {
+		return nil, errors.New("pattern is empty")
+	}
+
+	expression := args.Pattern
+	if args.IgnoreCase {
+		expression = "(?i)" + expression
+	}
+
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %s", err)
+	}
+
+	for _, patterns := range [][]string{args.Include, args.Exclude} {
+		for _, pattern := range patterns {
+			if err := checkGlob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %s", pattern, err)
+			}
+		}
+	}
+
+	limit := args.MaxResults
+	if limit <= 0 {
+		limit = grepDefaultResults
+	}
+
+	limit = min(limit, grepMaxResults)
+	context := min(max(args.Context, 0), grepMaxContext)
+
+	root, err := dispatcher.sandbox(args.root())
+	if err != nil {
+		return nil, err
+	}
+
+	result := GrepResult{}
+
+	var buffer strings.Builder
+
+	errLimit := errors.New("limit reached")
+
+	err = dispatcher.walkWorkspace(root, func(name string, entry fs.DirEntry) error {
+		if !entry.Type().IsRegular() || !matchGlobs(name, args.Include, args.Exclude) {
+			return nil
+		}
+
+		// rules denying other tools to read the file apply to the search
+		if dispatcher.policy.Check("fs_grep", []string{name}) != nil {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.Size() > maxReadFileSize {
+			return nil
+		}
+
+		contents, err := dispatcher.readWorkspaceFile(name)
+		if err != nil || isBinary(contents[:min(len(contents), 8000)]) {
+			return nil
+		}
+
+		lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+
+		// last is the last line written of the file
+		last := -1
+
+		found := false
+		for number, line := range lines {
+			if !re.MatchString(line) {
+				continue
+			}
+
+			if result.Count == limit {
+				result.Truncated = true
+				return errLimit
+			}
+
+			if !found {
+				found = true
+				result.Files++
+			}
+
+			result.Count++
+
+			first := max(number-context, last+1)
+			if context > 0 && buffer.Len() > 0 && (last < 0 || first > last+1) {
+				buffer.WriteString("--\n")
+			}
+
+			for i := first; i <= min(number+context, len(lines)-1); i++ {
+				separator := "-"
+				if re.MatchString(lines[i]) {
+					separator = ":"
+				}
+
+				if i > number && separator == ":" {
+					// the next match writes the rest of the context
+					break
+				}
+
+				fmt.Fprintf(&buffer, "%s%s%d%s%s\n", name, separator, i+1, separator, grepLine(lines[i]))
+				last = i
+			}
+		}
+
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return nil, err
+	}
+
+	result.Matches = buffer.String()
+
+	return result, nil
+}
+
+func grepLine(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	if len(line) <= grepMaxLine {
+		return line
+	}
+
+	cut := truncateUTF8(line, grepMaxLine)
+
+	return cut + fmt.Sprintf("... (%d more bytes)", len(line)-len(cut))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, contents := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDispatcher_Grep(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	writeFiles(t, dispatcher.cwd, map[string]string{
+		".gitignore":         "build/\n*.log\n!keep.log\n",
+		"main.go":            "package main\n\n// TODO: one\nfunc main() {}\n",
+		"build/out.go":       "// TODO: built\n",
+		"debug.log":          "TODO: log\n",
+		"keep.log":           "TODO: kept\n",
+		"lib/.gitignore":     "/generated.go\n",
+		"lib/generated.go":   "// TODO: generated\n",
+		"lib/lib.go":         "a\nb\n// TODO: two\nc\nd\ne\nf\n// todo: three\n",
+		"lib/image.png":      "TODO\x00",
+		".git/config":        "TODO\n",
+		".aight/config.yaml": "TODO\n",
+	})
+
+	tests := []struct {
+		args     GrepArguments
+		expected string
+	}{
+		{
+			GrepArguments{Pattern: "TODO"},
+			"keep.log:1:TODO: kept\nlib/lib.go:3:// TODO: two\nmain.go:3:// TODO: one\n",
+		},
+		{
+			GrepArguments{Pattern: "todo", IgnoreCase: true, Path: "lib", Context: 1},
+			"lib/lib.go-2-b\nlib/lib.go:3:// TODO: two\nlib/lib.go-4-c\n--\n" +
+				"lib/lib.go-7-f\nlib/lib.go:8:// todo: three\n",
+		},
+		{
+			GrepArguments{Pattern: "TODO", Include: []string{"**/*.go"}, Exclude: []string{"lib/**"}},
+			"main.go:3:// TODO: one\n",
+		},
+	}
+	for _, test := range tests {
+		result, err := dispatcher.grep(test.args)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", test.args, err)
+			continue
+		}
+
+		if matches := result.(GrepResult).Matches; matches != test.expected {
+			t.Errorf("%+v: expected\n%s\ngot\n%s", test.args, test.expected, matches)
+		}
+	}
+
+	result, err := dispatcher.grep(GrepArguments{Pattern: "TODO", MaxResults: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if grep := result.(GrepResult); !grep.Truncated || grep.Count != 2 || grep.Files != 2 {
+		t.Errorf("expected the result to be truncated, got %+v", grep)
+	}
+
+	_, err = dispatcher.grep(GrepArguments{Pattern: "TODO", Path: "../"})
+	if err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("expected paths outside of the workspace to be rejected, got %v", err)
+	}
+}
+
+func TestGrepLine(t *testing.T) {
+	line := "x" + strings.Repeat("é", grepMaxLine)
+
+	cut := grepLine(line)
+	if !utf8.ValidString(cut) || !strings.HasSuffix(cut, "... (302 more bytes)") ||
+		len(cut) > grepMaxLine+len("... (302 more bytes)") {
+		t.Errorf("unexpected line: %q", cut)
+	}
+}
@@ -2,14 +2,22 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/docopt/docopt-go"
+	"github.com/fatih/color"
 	"github.com/liushuangls/go-anthropic/v2"
+	"github.com/reconquest/karma-go"
+	"go.uber.org/ratelimit"
 )
 
 const (
@@ -22,15 +30,56 @@ var (
 
 Usage:
   aight [options] [-p <text>]...
+  aight [options] threads list
+  aight [options] threads show <name>
+  aight [options] threads delete <name>
+  aight [options] threads rename <name> <new-name>
+  aight [options] threads rewind <name> <turn>
+  aight [options] threads fork <name> <turn> <new-name>
+  aight [options] undo [--turn <turn>]
   aight -h | --help
   aight --version
 
 Options:
   -p --prompt <text>  Prompt text.
-  -t --token <token>  Anthropic API token. [default: $ANTHROPIC_API_KEY]
-                       Environment variable is used if starts with $.
-  -m --model <model>  Model to use [default: ` + defaultModel + `]
+  -t --token <token>  API token. Environment variable is used if starts with $.
+                       By default $ANTHROPIC_API_KEY is used for anthropic
+                       and $OPENAI_API_KEY for openai.
+  -m --model <model>  Model to use if not set by the profile, ` + defaultModel + `
+                       for anthropic and ` + defaultOpenAIModel + ` for openai.
+  --provider <name>   Provider: anthropic, openai or script, anthropic
+                       if not set by the profile.
+  -P --profile <name> Profile of the config to use. Configs are read from
+                       ~/.config/aight/config.yaml and .aight/config.yaml
+                       of the working directory, flags override them.
+  --base-url <url>    Base URL of the provider API, e.g.
+                       http://localhost:8080/v1 for llama.cpp server.
+  --script <path>     YAML or JSON file with assistant turns to replay
+                       by the script provider.
   -w --cwd <path>     Working directory [default: .].
+  -s --system <file>  Use the file as the system prompt instead of the
+                       built-in one. AIGHT.md and .aight/instructions.md
+                       of the working directory are appended to it.
+  -T --thread <name>  Thread to continue, threads are stored in
+                       .aight/threads of the working directory
+                       [default: ` + defaultThreadName + `].
+  --turn <turn>       Turn of the thread to undo changes of the workspace
+                       since, the last turn that changed it by default.
+  --no-stream         Print the assistant output only once it's complete.
+  -y --yes            Run tools that change the workspace without asking,
+                       except shell commands if confirm is set for them
+                       in the profile.
+  --read-only         Provide only tools that don't change the workspace.
+  --dry-run           Simulate changes of the workspace in memory and print
+                       them as a diff at the end.
+  --auto-commit       Commit changes of every turn to the aight/<thread>
+                       branch of the workspace repository, HEAD and the
+                       index are left as they are.
+  --compact <tokens>  Summarize older turns once the thread is estimated
+                       to exceed the number of tokens, 0 disables it,
+                       ` + fmt.Sprint(defaultCompactThreshold) + ` if not set by the profile.
+  --record <dir>      Record API traffic into the directory.
+  --replay <dir>      Replay API traffic recorded by --record.
   -v --verbose        Verbose mode.
   -h --help           Show this screen.
   --version           Show version.
@@ -42,8 +91,36 @@ type Arguments struct {
 	ValueModel            string   `docopt:"--model"`
 	ValueWorkingDirectory string   `docopt:"--cwd"`
 	ValueToken            string   `docopt:"--token"`
-
-	FlagVerbose bool `docopt:"--verbose"`
+	ValueProvider         string   `docopt:"--provider"`
+	ValueBaseURL          string   `docopt:"--base-url"`
+	ValueScript           string   `docopt:"--script"`
+	ValueRecord           string   `docopt:"--record"`
+	ValueReplay           string   `docopt:"--replay"`
+	ValueThread           string   `docopt:"--thread"`
+	ValueName             string   `docopt:"<name>"`
+	ValueNewName          string   `docopt:"<new-name>"`
+	ValueTurn             string   `docopt:"<turn>"`
+	ValueUndoTurn         string   `docopt:"--turn"`
+	ValueCompact          string   `docopt:"--compact"`
+	ValueSystem           string   `docopt:"--system"`
+	ValueProfile          string   `docopt:"--profile"`
+
+	CommandThreads bool `docopt:"threads"`
+	CommandList    bool `docopt:"list"`
+	CommandShow    bool `docopt:"show"`
+	CommandDelete  bool `docopt:"delete"`
+	CommandRename  bool `docopt:"rename"`
+	CommandRewind  bool `docopt:"rewind"`
+	CommandFork    bool `docopt:"fork"`
+	CommandUndo    bool `docopt:"undo"`
+
+	FlagVerbose  bool `docopt:"--verbose"`
+	FlagNoStream bool `docopt:"--no-stream"`
+	FlagYes      bool `docopt:"--yes"`
+	FlagReadOnly bool `docopt:"--read-only"`
+	FlagDryRun   bool `docopt:"--dry-run"`
+
+	FlagAutoCommit bool `docopt:"--auto-commit"`
 }
 
 func main() {
@@ -72,67 +149,328 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// the system prompt path is relative to the directory aight is run in
+	systemPath := args.ValueSystem
+	if systemPath != "" {
+		systemPath, err = filepath.Abs(systemPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	err = os.Chdir(cwd)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	token := args.ValueToken
-	if strings.HasPrefix(token, "$") {
-		token = os.Getenv(token[1:])
+	threads := NewThreadStore(cwd)
 
-		if token == "" {
-			log.Fatalf(
-				"the environment variable %s is not set. "+
-					"Specify the environment value or pass it via --token flag.",
-				args.ValueToken,
-			)
+	migrated, err := threads.Migrate(cwd)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if migrated {
+		log.Printf("migrated %s to the %q thread", legacyThreadFile, defaultThreadName)
+	}
+
+	if args.CommandThreads {
+		err := runThreadsCommand(threads, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if args.CommandUndo {
+		err := runUndoCommand(cwd, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	config, err := LoadConfig(configPaths(cwd))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	profile, err := config.GetProfile(args.ValueProfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var compact *int
+	if args.ValueCompact != "" {
+		value, err := strconv.Atoi(args.ValueCompact)
+		if err != nil || value < 0 {
+			log.Fatalf("invalid number of tokens to compact at: %s", args.ValueCompact)
+		}
+
+		compact = &value
+	}
+
+	profile = profile.merge(Profile{
+		Provider: args.ValueProvider,
+		Model:    args.ValueModel,
+		BaseURL:  args.ValueBaseURL,
+		Token:    args.ValueToken,
+		System:   systemPath,
+		Compact:  compact,
+	}).withDefaults()
+
+	if args.FlagVerbose {
+		masked := profile
+		if masked.Token != "" && !strings.HasPrefix(masked.Token, "$") {
+			masked.Token = "***"
 		}
+
+		log.Printf("profile: %+v", masked)
+	}
+
+	requestRateLimit = ratelimit.New(profile.RateLimit)
+
+	httpClient, err := newCassetteClient(args.ValueRecord, args.ValueReplay)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	provider, err := NewProvider(ProviderOptions{
+		Name:       profile.Provider,
+		BaseURL:    profile.BaseURL,
+		Token:      profile.Token,
+		Script:     args.ValueScript,
+		HTTPClient: httpClient,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	dispatcher := NewDispatcher(
 		cwd,
-		args.ValueModel,
+		profile.Model,
 		args.FlagVerbose,
-		token,
+		provider,
 	)
 
+	dispatcher.maxTokens = profile.MaxTokens
+	dispatcher.temperature = profile.Temperature
+
+	if profile.Tools != nil {
+		err = dispatcher.EnableTools(profile.Tools)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if args.FlagReadOnly {
+		dispatcher.DisableMutating()
+	}
+
+	if len(profile.Rules) > 0 {
+		dispatcher.policy, err = NewPolicy(cwd, profile.Rules)
+		if err != nil {
+			log.Fatal(karma.Format(err, "invalid rules of the profile"))
+		}
+	}
+
+	dispatcher.system, err = LoadSystemPrompt(cwd, profile.System, dispatcher.tools)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dispatcher.threadName = args.ValueThread
+	dispatcher.compactThreshold = *profile.Compact
+
+	dispatcher.shell = profile.Shell
+	dispatcher.pythonOptions = profile.Python
+	dispatcher.yes = args.FlagYes
+	dispatcher.autoCommit = args.FlagAutoCommit
+	if dispatcher.autoCommit {
+		err = dispatcher.checkAutoCommit()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	confirm := []string{}
+	if profile.Shell.Confirm {
+		confirm = append(confirm, "shell_exec")
+		dispatcher.confirm["shell_exec"] = true
+	}
+
+	// nothing is changed in dry-run mode, so there is nothing to approve
+	if !args.FlagDryRun {
+		dispatcher.approver = NewTerminalApprover(os.Stdin, os.Stderr, confirm...)
+	}
+
+	if !args.FlagNoStream {
+		dispatcher.stream = NewTerminalStream(os.Stdout)
+	}
+
 	err = dispatcher.readThread()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	//if len(dispatcher.thread) == 0 {
-	//    err := dispatcher.WriteMessage(anthropic.Message{
-	//        Role: anthropic.RoleUser,
-	//        Content: []anthropic.MessageContent{
-	//            anthropic.NewTextMessageContent(defaultSystemMessage),
-	//        },
-	//    })
-	//    if err != nil {
-	//        log.Fatal(err)
-	//    }
-	//}
+	if args.FlagDryRun {
+		dispatcher.overlay, err = NewOverlay(cwd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// the session is usually ended by ^C
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			<-interrupts
+
+			printDryRun(dispatcher.overlay)
+
+			os.Exit(130)
+		}()
+	}
+
+	err = run(dispatcher, NewPrompter(args.ValuePrompt, PromptStdin))
+
+	if dispatcher.overlay != nil {
+		printDryRun(dispatcher.overlay)
+	}
+
+	if err != nil {
+		if karma.Contains(err, ErrNoMoreCompletions) {
+			return
+		}
+
+		log.Fatal(err)
+	}
+}
+
+// printDryRun prints changes simulated in dry-run mode and removes the
+// temporary copies.
+func printDryRun(overlay *Overlay) {
+	role := color.YellowString("dry-run")
 
-	index := 0
-	prompt := func() string {
-		if index >= len(args.ValuePrompt) {
-			return PromptStdin()
+	diff := overlay.Diff()
+	if diff == "" {
+		log.Printf("{%s} no changes", role)
+	} else {
+		log.Printf("{%s} changes of the workspace:", role)
+
+		fmt.Println(colorizeDiff(diff))
+	}
+
+	err := overlay.Close()
+	if err != nil {
+		log.Println(karma.Format(err, "remove temporary files"))
+	}
+}
+
+func runThreadsCommand(threads *ThreadStore, args Arguments) error {
+	switch {
+	case args.CommandList:
+		list, err := threads.List()
+		if err != nil {
+			return err
 		}
 
-		result := args.ValuePrompt[index]
+		printThreads(os.Stdout, list)
 
-		index++
+	case args.CommandShow:
+		thread, err := threads.readExisting(args.ValueName)
+		if err != nil {
+			return err
+		}
 
-		fmt.Fprintln(os.Stderr, result)
+		printThread(os.Stdout, thread)
 
-		return result
+	case args.CommandDelete:
+		return threads.Delete(args.ValueName)
+
+	case args.CommandRename:
+		return threads.Rename(args.ValueName, args.ValueNewName)
+
+	case args.CommandRewind, args.CommandFork:
+		turn, err := strconv.Atoi(args.ValueTurn)
+		if err != nil {
+			return karma.Format(err, "invalid turn: %s", args.ValueTurn)
+		}
+
+		var thread []Message
+		if args.CommandRewind {
+			thread, err = threads.Rewind(args.ValueName, turn)
+		} else {
+			thread, err = threads.Fork(args.ValueName, turn, args.ValueNewName)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(thread) != turn {
+			log.Printf(
+				"the thread is cut at turn %d to keep tool calls paired with results",
+				len(thread),
+			)
+		}
+
+		printThread(os.Stdout, thread)
+	}
+
+	return nil
+}
+
+func runUndoCommand(cwd string, args Arguments) error {
+	turn := 0
+	if args.ValueUndoTurn != "" {
+		var err error
+		turn, err = strconv.Atoi(args.ValueUndoTurn)
+		if err != nil || turn < 1 {
+			return fmt.Errorf("invalid turn: %s", args.ValueUndoTurn)
+		}
 	}
 
+	dispatcher := NewDispatcher(cwd, "", args.FlagVerbose, nil)
+	dispatcher.threadName = args.ValueThread
+
+	return dispatcher.Undo(turn)
+}
+
+func newCassetteClient(record string, replay string) (*http.Client, error) {
+	if record != "" && replay != "" {
+		return nil, errors.New("--record and --replay are mutually exclusive")
+	}
+
+	var (
+		cassette *Cassette
+		err      error
+	)
+
+	switch {
+	case record != "":
+		cassette, err = NewCassette(record, CassetteRecord)
+	case replay != "":
+		cassette, err = NewCassette(replay, CassetteReplay)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cassette.Client(), nil
+}
+
+// run asks for the user input if the thread is waiting for it and then
+// communicates with the provider until it fails.
+func run(dispatcher *Dispatcher, prompt func() string) error {
 	ask := len(dispatcher.thread) == 0
 	if len(dispatcher.thread) > 0 {
 		last := dispatcher.thread[len(dispatcher.thread)-1]
-		if last.Role != anthropic.RoleUser {
+		if last.Role != RoleUser {
 			ask = true
 		}
 	}
@@ -140,18 +478,38 @@ func main() {
 	if ask {
 		err := dispatcher.interact(prompt)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
 	for {
-		err = dispatcher.Communicate(prompt)
+		err := dispatcher.Communicate(prompt)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 }
 
+// NewPrompter returns prompts passed via flags one by one and then falls
+// back to the given function.
+func NewPrompter(prompts []string, fallback func() string) func() string {
+	index := 0
+
+	return func() string {
+		if index >= len(prompts) {
+			return fallback()
+		}
+
+		result := prompts[index]
+
+		index++
+
+		fmt.Fprintln(os.Stderr, result)
+
+		return result
+	}
+}
+
 func PromptStdin() string {
 	for {
 		fmt.Println()
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reconquest/karma-go"
+)
+
+func runScript(t *testing.T, script string, prompts ...string) *Dispatcher {
+	t.Helper()
+
+	return runScriptWith(t, script, func(*Dispatcher) {}, prompts...)
+}
+
+// runScriptWith runs the script after the setup of the dispatcher.
+func runScriptWith(
+	t *testing.T,
+	script string,
+	setup func(*Dispatcher),
+	prompts ...string,
+) *Dispatcher {
+	t.Helper()
+
+	provider, err := NewScriptProvider(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewDispatcher(t.TempDir(), "script", false, provider)
+
+	setup(dispatcher)
+
+	prompt := NewPrompter(prompts, func() string {
+		return "continue"
+	})
+
+	err = run(dispatcher, prompt)
+	if !karma.Contains(err, ErrNoMoreCompletions) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return dispatcher
+}
+
+func toolResults(dispatcher *Dispatcher) map[string]any {
+	results := map[string]any{}
+	for _, message := range dispatcher.thread {
+		for _, content := range message.Content {
+			if content.Type != ContentTypeToolResult {
+				continue
+			}
+
+			var value any
+			err := json.Unmarshal([]byte(content.ToolResult.Content), &value)
+			if err != nil {
+				value = content.ToolResult.Content
+			}
+
+			results[content.ToolResult.ToolUseID] = value
+		}
+	}
+
+	return results
+}
+
+// TestRun_CoversEveryTool checks that every registered tool is reachable
+// through the dispatch loop and returns a real result, edge cases of each
+// tool are covered by the tests next to it.
+func TestRun_CoversEveryTool(t *testing.T) {
+	script := filepath.Join("testdata", "tools.yaml")
+
+	// the git tools inspect the changes of the script to the first commit
+	dispatcher := runScriptWith(t, script, func(dispatcher *Dispatcher) {
+		initGitRepo(t, dispatcher, map[string]string{"notes.txt": "hi\n"})
+	}, "do it")
+
+	used := map[string]bool{}
+	for _, message := range dispatcher.thread {
+		for _, content := range message.Content {
+			if content.Type == ContentTypeToolUse {
+				used[content.ToolUse.Name] = true
+			}
+		}
+	}
+
+	registered := map[string]bool{}
+	for _, tool := range dispatcher.tools {
+		registered[tool.Name] = true
+
+		if !used[tool.Name] {
+			t.Errorf("tool %s is not covered by %s", tool.Name, script)
+		}
+	}
+
+	results := toolResults(dispatcher)
+
+	expected := map[string]any{
+		"write_notes": true,
+		"write_main":  true,
+		"move_notes":  true,
+		"remove_main": true,
+	}
+	for id, value := range expected {
+		if results[id] != value {
+			t.Errorf("%s: expected %#v, got %#v", id, value, results[id])
+		}
+	}
+
+	if read := silentMarshal(results["read_notes"]); read != `{"contents":"1\thello\n","first_line":1,"last_line":1,"total_lines":1}` {
+		t.Errorf("read_notes: unexpected result %s", read)
+	}
+
+	if _, ok := results["list_root"].([]any); !ok {
+		t.Errorf("list_root: unexpected result %#v", results["list_root"])
+	}
+
+	if tree, ok := results["tree_root"].(map[string]any); !ok || tree["tree"] != "./\n  notes.txt\n  src/\n    main.go\n" {
+		t.Errorf("tree_root: unexpected result %#v", results["tree_root"])
+	}
+
+	if reply, ok := results["exec_sql"].(map[string]any); !ok || reply["rows_affected"] != float64(1) {
+		t.Errorf("exec_sql: unexpected result %#v", results["exec_sql"])
+	}
+
+	if grep, ok := results["grep_hello"].(map[string]any); !ok || grep["matches"] != "notes.txt:1:hello\n" {
+		t.Errorf("grep_hello: unexpected result %#v", results["grep_hello"])
+	}
+
+	if glob := silentMarshal(results["glob_go"]); !strings.Contains(glob, `"path":"src/main.go","size":75}]`) {
+		t.Errorf("glob_go: unexpected result %s", glob)
+	}
+
+	expectedGo := map[string]string{
+		"go_symbols":    `{"package":"main","symbols":"src/main.go:4 func main()\n"}`,
+		"go_definition": `{"doc":"main does nothing.\n","kind":"func","position":"src/main.go:4:6","signature":"func main()"}`,
+		"go_references": `{"count":1,"definition":"src/main.go:4:6","references":"src/main.go:6:15: func init() { main() }\n"}`,
+		"go_source":     `{"position":"src/main.go:3","source":"// main does nothing.\nfunc main() {}\n"}`,
+	}
+	for id, value := range expectedGo {
+		if silentMarshal(results[id]) != value {
+			t.Errorf("%s: expected %s, got %s", id, value, silentMarshal(results[id]))
+		}
+	}
+
+	expectedGit := map[string]string{
+		"git_status": "## master\n M notes.txt\n?? .aight/\n?? src/\n",
+		"git_diff":   " notes.txt | 2 +-\n 1 file changed, 1 insertion(+), 1 deletion(-)\n",
+		"git_show":   "hi\n",
+	}
+	for id, output := range expectedGit {
+		if git, ok := results[id].(map[string]any); !ok || git["output"] != output {
+			t.Errorf("%s: expected output %q, got %#v", id, output, results[id])
+		}
+	}
+
+	if git, ok := results["git_log"].(map[string]any); !ok ||
+		!strings.HasSuffix(git["output"].(string), " Tester Initial commit\n") {
+		t.Errorf("git_log: unexpected result %#v", results["git_log"])
+	}
+
+	if patch, ok := results["patch_notes"].(map[string]any); !ok || patch["applied"] != true {
+		t.Errorf("patch_notes: unexpected result %#v", results["patch_notes"])
+	}
+
+	edit, ok := results["edit_notes"].(map[string]any)
+	if !ok || edit["replacements"] != float64(1) ||
+		edit["diff"] != "--- a/notes.txt\n+++ b/notes.txt\n@@ -1 +1 @@\n-hello world\n+hello, world\n" {
+		t.Errorf("edit_notes: unexpected result %#v", results["edit_notes"])
+	}
+
+	shell, ok := results["exec_shell"].(map[string]any)
+	if !ok || shell["exit_code"] != float64(3) || shell["output"] != "hello, world\n" {
+		t.Errorf("exec_shell: unexpected result %#v", results["exec_shell"])
+	}
+
+	rows, ok := results["query_sql"].([]any)
+	if !ok || len(rows) != 1 {
+		t.Fatalf("query_sql: unexpected result %#v", results["query_sql"])
+	}
+
+	if row := rows[0].(map[string]any); row["id"] != float64(1) || row["name"] != "one" {
+		t.Errorf("query_sql: unexpected row %#v", row)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dispatcher.cwd, "renamed.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(contents) != "hello, world\n" {
+		t.Errorf("renamed.txt: unexpected contents %q", contents)
+	}
+
+	if _, err := os.Stat(filepath.Join(dispatcher.cwd, "src", "main.go")); !os.IsNotExist(err) {
+		t.Errorf("src/main.go: expected to be removed, got %v", err)
+	}
+
+	// python_execute is registered only where the sandbox is supported
+	if !registered["python_execute"] {
+		return
+	}
+
+	if message, ok := results["exec_python"].(string); ok {
+		t.Skipf("python sandbox is not available: %s", message)
+	}
+
+	python, ok := results["exec_python"].(map[string]any)
+	if !ok || python["exit_code"] != float64(0) || python["output"] != "42\n" {
+		t.Errorf("exec_python: unexpected result %#v", results["exec_python"])
+	}
+}
+
+func TestRun_PersistsThread(t *testing.T) {
+	dispatcher := runScript(t, filepath.Join("testdata", "tools.yaml"), "do it")
+
+	restored := NewDispatcher(dispatcher.cwd, "script", false, nil)
+
+	err := restored.readThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := json.Marshal(dispatcher.thread)
+	actual, _ := json.Marshal(restored.thread)
+	if string(expected) != string(actual) {
+		t.Fatalf("persisted thread differs:\n%s\n%s", expected, actual)
+	}
+
+	first := restored.thread[0]
+	if first.Role != RoleUser || first.Content[0].Text != "do it" {
+		t.Errorf("unexpected first message: %#v", first)
+	}
+
+	last := restored.thread[len(restored.thread)-1]
+	if last.Role != RoleUser || last.Content[0].Text != "continue" {
+		t.Errorf("unexpected last message: %#v", last)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reconquest/karma-go"
+)
+
+// Overlay keeps changes of the workspace in memory for --dry-run, so tools
+// that read files see the changes while the workspace stays untouched.
+// Databases are changed in temporary copies. Names are relative to the
+// root and are expected to be checked by the sandbox.
+type Overlay struct {
+	root string
+
+	// dir keeps copies of databases
+	dir string
+
+	files     map[string]*overlayFile
+	databases map[string]string
+
+	mutex sync.Mutex
+}
+
+type overlayFile struct {
+	original []byte
+	existed  bool
+
+	contents []byte
+	removed  bool
+	modified time.Time
+}
+
+// OverlayEntry is a file of a directory listing changed by the overlay.
+type OverlayEntry struct {
+	Name     string
+	Dir      bool
+	Size     int64
+	Removed  bool
+	Modified time.Time
+}
+
+func NewOverlay(root string) (*Overlay, error) {
+	dir, err := os.MkdirTemp("", "aight-dry-run-")
+	if err != nil {
+		return nil, karma.Format(err, "create temporary directory")
+	}
+
+	return &Overlay{
+		root:      root,
+		dir:       dir,
+		files:     map[string]*overlayFile{},
+		databases: map[string]string{},
+	}, nil
+}
+
+// Close removes temporary copies of the overlay.
+func (overlay *Overlay) Close() error {
+	return os.RemoveAll(overlay.dir)
+}
+
+// Read returns contents of the file if it's changed by the overlay, ok is
+// false if the file should be read from the workspace.
+func (overlay *Overlay) Read(name string) ([]byte, bool, error) {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+
+	file, ok := overlay.files[filepath.Clean(name)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if file.removed {
+		return nil, true, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return file.contents, true, nil
+}
+
+func (overlay *Overlay) Write(name string, contents []byte, append bool) error {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+
+	file, err := overlay.load(name)
+	if err != nil {
+		return err
+	}
+
+	if append && !file.removed {
+		contents = bytes.Join([][]byte{file.contents, contents}, nil)
+	}
+
+	file.contents = contents
+	file.removed = false
+	file.modified = time.Now()
+
+	return nil
+}
+
+func (overlay *Overlay) Move(from string, to string) error {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+
+	source, err := overlay.load(from)
+	if err != nil {
+		return err
+	}
+
+	if source.removed {
+		return &os.PathError{Op: "rename", Path: from, Err: os.ErrNotExist}
+	}
+
+	target, err := overlay.load(to)
+	if err != nil {
+		return err
+	}
+
+	target.contents = source.contents
+	target.removed = false
+	target.modified = time.Now()
+
+	source.contents = nil
+	source.removed = true
+	source.modified = target.modified
+
+	return nil
+}
+
+func (overlay *Overlay) Remove(name string) error {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+
+	file, err := overlay.load(name)
+	if err != nil {
+		return err
+	}
+
+	if file.removed {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	file.contents = nil
+	file.removed = true
+	file.modified = time.Now()
+
+	return nil
+}
+
+// load returns the tracked file, reading it from the workspace first time.
+func (overlay *Overlay) load(name string) (*overlayFile, error) {
+	name = filepath.Clean(name)
+
+	if file, ok := overlay.files[name]; ok {
+		return file, nil
+	}
+
+	path := filepath.Join(overlay.root, name)
+
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		return nil, fmt.Errorf("directories are not supported in dry-run mode: %s", name)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, karma.Format(err, "read file: %s", name)
+	}
+
+	file := &overlayFile{
+		original: contents,
+		existed:  err == nil,
+		contents: contents,
+		removed:  err != nil,
+	}
+
+	overlay.files[name] = file
+
+	return file, nil
+}
+
+// Entries returns files of the directory that are changed by the overlay,
+// directories that only exist in the overlay are returned as well.
+func (overlay *Overlay) Entries(dir string) []OverlayEntry {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+
+	dir = filepath.Clean(dir)
+
+	entries := map[string]OverlayEntry{}
+	for name, file := range overlay.files {
+		relative, err := filepath.Rel(dir, name)
+		if err != nil || !isLocal(relative) || relative == "." {
+			continue
+		}
+
+		parts := strings.Split(relative, string(filepath.Separator))
+		if len(parts) > 1 {
+			if !file.removed {
+				entries[parts[0]] = OverlayEntry{Name: parts[0], Dir: true, Modified: file.modified}
+			}
+
+			continue
+		}
+
+		if _, ok := entries[relative]; ok {
+			continue
+		}
+
+		entries[relative] = OverlayEntry{
+			Name:     relative,
+			Size:     int64(len(file.contents)),
+			Removed:  file.removed,
+			Modified: file.modified,
+		}
+	}
+
+	result := []OverlayEntry{}
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// DirEntry returns the entry as a file of a walked directory.
+func (entry OverlayEntry) DirEntry() fs.DirEntry {
+	return overlayDirEntry{entry}
+}
+
+// overlayDirEntry is both fs.DirEntry and fs.FileInfo of the entry.
+type overlayDirEntry struct {
+	entry OverlayEntry
+}
+
+func (entry overlayDirEntry) Name() string {
+	return entry.entry.Name
+}
+
+func (entry overlayDirEntry) IsDir() bool {
+	return entry.entry.Dir
+}
+
+func (entry overlayDirEntry) Type() fs.FileMode {
+	return entry.Mode().Type()
+}
+
+func (entry overlayDirEntry) Info() (fs.FileInfo, error) {
+	return entry, nil
+}
+
+func (entry overlayDirEntry) Size() int64 {
+	return entry.entry.Size
+}
+
+func (entry overlayDirEntry) ModTime() time.Time {
+	return entry.entry.Modified
+}
+
+func (entry overlayDirEntry) Sys() any {
+	return nil
+}
+
+func (entry overlayDirEntry) Mode() fs.FileMode {
+	if entry.entry.Dir {
+		return fs.ModeDir | 0755
+	}
+
+	return 0644
+}
+
+// Database returns path of the database to use, for writes the database
+// is copied first.
+func (overlay *Overlay) Database(name string, write bool) (string, error) {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+
+	name = filepath.Clean(name)
+
+	if path, ok := overlay.databases[name]; ok {
+		return path, nil
+	}
+
+	path := filepath.Join(overlay.root, name)
+	if !write {
+		return path, nil
+	}
+
+	copied := filepath.Join(overlay.dir, "databases", name)
+
+	err := os.MkdirAll(filepath.Dir(copied), 0755)
+	if err != nil {
+		return "", karma.Format(err, "create directory: %s", filepath.Dir(copied))
+	}
+
+	err = copyFile(path, copied)
+	if err != nil && !os.IsNotExist(err) {
+		return "", karma.Format(err, "copy database: %s", name)
+	}
+
+	overlay.databases[name] = copied
+
+	return copied, nil
+}
+
+// Diff returns all changes of the overlay as a unified diff.
+func (overlay *Overlay) Diff() string {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+
+	names := []string{}
+	for name := range overlay.files {
+		names = append(names, name)
+	}
+
+	for name := range overlay.databases {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var buffer strings.Builder
+	for _, name := range names {
+		slashed := filepath.ToSlash(name)
+
+		if copied, ok := overlay.databases[name]; ok {
+			if !sameFile(filepath.Join(overlay.root, name), copied) {
+				fmt.Fprintf(&buffer, "Binary files a/%s and b/%s differ\n", slashed, slashed)
+			}
+
+			continue
+		}
+
+		file := overlay.files[name]
+		if file.existed == !file.removed && bytes.Equal(file.original, file.contents) {
+			continue
+		}
+
+		if isBinary(file.original) || isBinary(file.contents) {
+			fmt.Fprintf(&buffer, "Binary files a/%s and b/%s differ\n", slashed, slashed)
+			continue
+		}
+
+		buffer.WriteString(unifiedDiff(
+			slashed,
+			string(file.original),
+			string(file.contents),
+			!file.existed,
+			file.removed,
+		))
+	}
+
+	return buffer.String()
+}
+
+func isBinary(contents []byte) bool {
+	return bytes.IndexByte(contents, 0) >= 0
+}
+
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+
+	defer source.Close()
+
+	target, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+
+	defer target.Close()
+
+	_, err = io.Copy(target, source)
+	if err != nil {
+		return err
+	}
+
+	return target.Close()
+}
+
+func sameFile(a string, b string) bool {
+	first, errA := os.ReadFile(a)
+	second, errB := os.ReadFile(b)
+
+	if os.IsNotExist(errA) && os.IsNotExist(errB) {
+		return true
+	}
+
+	return errA == nil && errB == nil && bytes.Equal(first, second)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRun_DryRun(t *testing.T) {
+	dispatcher := runScriptWith(
+		t,
+		filepath.Join("testdata", "tools.yaml"),
+		func(dispatcher *Dispatcher) {
+			var err error
+			dispatcher.overlay, err = NewOverlay(dispatcher.cwd)
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+		"do it",
+	)
+
+	defer dispatcher.overlay.Close()
+
+	results := toolResults(dispatcher)
+	if !strings.Contains(silentMarshal(results["read_notes"]), `"contents":"1\thello\n"`) {
+		t.Errorf("read_notes: expected write to be visible, got %#v", results["read_notes"])
+	}
+
+	if !strings.Contains(silentMarshal(results["list_root"]), `"name":"notes.txt"`) {
+		t.Errorf("list_root: expected notes.txt to be listed, got %#v", results["list_root"])
+	}
+
+	if rows, ok := results["query_sql"].([]any); !ok || len(rows) != 1 {
+		t.Errorf("query_sql: expected the copy to be queried, got %#v", results["query_sql"])
+	}
+
+	entries, err := os.ReadDir(dispatcher.cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != stateDir {
+			t.Errorf("workspace is changed in dry-run mode: %s", entry.Name())
+		}
+	}
+
+	expected := "--- /dev/null\n+++ b/renamed.txt\n@@ -0,0 +1 @@\n+hello, world\n" +
+		"Binary files a/test.db and b/test.db differ\n"
+
+	if diff := dispatcher.overlay.Diff(); diff != expected {
+		t.Errorf("unexpected diff:\n%s\nexpected:\n%s", diff, expected)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	root := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(root, "old.txt"), []byte("a\nb\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overlay, err := NewOverlay(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer overlay.Close()
+
+	if _, ok, _ := overlay.Read("old.txt"); ok {
+		t.Error("untouched file must be read from the workspace")
+	}
+
+	err = overlay.Write("old.txt", []byte("c\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, ok, err := overlay.Read("old.txt")
+	if !ok || err != nil || string(contents) != "a\nb\nc\n" {
+		t.Errorf("expected appended contents, got %q %v %v", contents, ok, err)
+	}
+
+	err = overlay.Write("dir/new.txt", []byte("new\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = overlay.Remove("old.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := overlay.Read("old.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected removed file to not exist, got %v", err)
+	}
+
+	if err := overlay.Remove("old.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected removed file to not exist, got %v", err)
+	}
+
+	names := []string{}
+	for _, entry := range overlay.Entries(".") {
+		names = append(names, entry.Name)
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "dir,old.txt" {
+		t.Errorf("unexpected entries: %v", names)
+	}
+
+	expected := "--- /dev/null\n+++ b/dir/new.txt\n@@ -0,0 +1 @@\n+new\n" +
+		"--- a/old.txt\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-a\n-b\n"
+
+	if diff := overlay.Diff(); diff != expected {
+		t.Errorf("unexpected diff:\n%s\nexpected:\n%s", diff, expected)
+	}
+
+	if contents, _ := os.ReadFile(filepath.Join(root, "old.txt")); string(contents) != "a\nb\n" {
+		t.Errorf("workspace is changed: %q", contents)
+	}
+}
+
+func TestDispatcher_SearchDryRun(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	writeFiles(t, dispatcher.cwd, map[string]string{
+		"edited.txt":  "needle before\n",
+		"removed.txt": "needle removed\n",
+		"src/kept.go": "package src\n",
+	})
+
+	var err error
+	dispatcher.overlay, err = NewOverlay(dispatcher.cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dispatcher.overlay.Close()
+
+	changes := []func() (any, error){
+		func() (any, error) {
+			return dispatcher.writeFile(WriteFileArguments{Path: "edited.txt", Contents: "needle after\n"})
+		},
+		func() (any, error) {
+			return dispatcher.writeFile(WriteFileArguments{Path: "new/created.go", Contents: "// needle\n"})
+		},
+		func() (any, error) {
+			return dispatcher.removeFile(RemoveFileArguments{Path: "removed.txt"})
+		},
+	}
+	for _, change := range changes {
+		_, err := change()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := dispatcher.grep(GrepArguments{Pattern: "needle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matches := result.(GrepResult).Matches; matches != "edited.txt:1:needle after\nnew/created.go:1:// needle\n" {
+		t.Errorf("unexpected matches:\n%s", matches)
+	}
+
+	result, err = dispatcher.glob(GlobArguments{Pattern: "**/*.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := result.(GlobResult).Files
+	if len(files) != 2 || files[0].Path != "new/created.go" || files[0].Size != 10 {
+		t.Errorf("expected the created file first, got %+v", files)
+	}
+
+	result, err = dispatcher.treeFiles(TreeFilesArguments{Path: "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "./\n  edited.txt\n  new/\n    created.go\n  src/\n    kept.go\n"
+	if tree := result.(TreeResult).Tree; tree != expected {
+		t.Errorf("unexpected tree:\n%s", tree)
+	}
+
+	result, err = dispatcher.grep(GrepArguments{Pattern: "needle", Path: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matches := result.(GrepResult).Matches; matches != "new/created.go:1:// needle\n" {
+		t.Errorf("unexpected matches of the created directory:\n%s", matches)
+	}
+}
+
+func TestDispatcher_DisableMutating(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	dispatcher.DisableMutating()
+
+	names := []string{}
+	for _, tool := range dispatcher.tools {
+		names = append(names, tool.Name)
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "fs_glob,fs_grep,fs_list,fs_read,fs_tree,"+
+		"git_diff,git_log,git_show,git_status,go_definition,go_references,go_source,go_symbols,sql_query" {
+		t.Errorf("unexpected tools: %v", names)
+	}
+
+	if _, ok := dispatcher.funcs["fs_write"]; ok {
+		t.Error("fs_write must not be callable")
+	}
+}
@@ -0,0 +1,951 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// patchMaxFuzz is the number of context lines that may be ignored at each
+// end of a hunk that doesn't match otherwise.
+const patchMaxFuzz = 2
+
+var reHunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+type PatchFileArguments struct {
+	Patch string `json:"patch"`
+}
+
+// Paths returns files changed by the patch.
+func (args PatchFileArguments) Paths() []string {
+	files, err := parsePatch(args.Patch)
+	if err != nil {
+		return nil
+	}
+
+	paths := []string{}
+	for _, file := range files {
+		for _, name := range []string{file.from, file.to} {
+			if name != "" {
+				paths = append(paths, name)
+			}
+		}
+	}
+
+	return paths
+}
+
+type PatchResult struct {
+	Applied bool              `json:"applied"`
+	Files   []PatchFileResult `json:"files"`
+}
+
+type PatchFileResult struct {
+	Path   string            `json:"path"`
+	From   string            `json:"from,omitempty"`
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Hunks  []PatchHunkResult `json:"hunks,omitempty"`
+}
+
+type PatchHunkResult struct {
+	Hunk    string `json:"hunk"`
+	Applied bool   `json:"applied"`
+
+	// Line is the line of the file the hunk is applied at
+	Line int `json:"line,omitempty"`
+
+	// Offset is the distance from the line given in the hunk header
+	Offset int `json:"offset,omitempty"`
+
+	// Fuzz is the number of context lines ignored at each end of the hunk
+	Fuzz int `json:"fuzz,omitempty"`
+
+	Whitespace bool   `json:"ignored_whitespace,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type patchFile struct {
+	// from and to are empty for created and removed files
+	from string
+	to   string
+
+	hunks []patchHunk
+}
+
+type patchHunk struct {
+	header string
+
+	// oldStart is the line of the hunk in the original file, zero if the
+	// header has no line numbers
+	oldStart int
+	oldCount int
+
+	// newCount is the number of lines of the new side, counts are -1 if
+	// the header has no line numbers
+	newCount int
+
+	lines []patchLine
+
+	// oldEOF and newEOF are set if the old or the new side of the hunk has
+	// no newline at the end of the file
+	oldEOF bool
+	newEOF bool
+}
+
+type patchLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// parsePatch parses unified and git diffs. A hunk is read up to the line
+// counts of its header, so removed and added lines like "-- comment" are
+// not taken for ---/+++ file headers. The counts are often wrong, so the
+// hunk ends early at a line that can't be a part of it and continues after
+// the counts up to such a line.
+func parsePatch(text string) ([]*patchFile, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var files []*patchFile
+	var file *patchFile
+
+	// git is set while the file is started by the diff --git header and
+	// has no ---/+++ headers yet
+	git := false
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			from, to := parseGitHeader(strings.TrimPrefix(line, "diff --git "))
+
+			file = &patchFile{from: from, to: to}
+			files = append(files, file)
+			git = true
+
+		case git && strings.HasPrefix(line, "new file mode"):
+			file.from = ""
+
+		case git && strings.HasPrefix(line, "deleted file mode"):
+			file.to = ""
+
+		case git && strings.HasPrefix(line, "rename from "):
+			file.from = strings.TrimPrefix(line, "rename from ")
+
+		case git && strings.HasPrefix(line, "rename to "):
+			file.to = strings.TrimPrefix(line, "rename to ")
+
+		case strings.HasPrefix(line, "GIT binary patch"),
+			strings.HasPrefix(line, "Binary files "):
+			return nil, errors.New("binary patches are not supported")
+
+		case strings.HasPrefix(line, "--- ") &&
+			i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			from := parsePatchName(line[4:])
+			to := parsePatchName(lines[i+1][4:])
+			i++
+
+			if !git {
+				file = &patchFile{}
+				files = append(files, file)
+			}
+
+			file.from, file.to = from, to
+			git = false
+
+		case strings.HasPrefix(line, "@@"):
+			if file == nil {
+				return nil, fmt.Errorf(
+					"line %d: hunk %q has no ---/+++ file headers before it",
+					i+1, line,
+				)
+			}
+
+			git = false
+
+			hunk := patchHunk{header: line, oldCount: -1, newCount: -1}
+
+			if match := reHunkHeader.FindStringSubmatch(line); match != nil {
+				hunk.oldStart, _ = strconv.Atoi(match[1])
+				hunk.oldCount = 1
+				if match[2] != "" {
+					hunk.oldCount, _ = strconv.Atoi(match[2])
+				}
+
+				hunk.newCount = 1
+				if match[4] != "" {
+					hunk.newCount, _ = strconv.Atoi(match[4])
+				}
+			}
+
+			i = parseHunk(lines, i+1, &hunk) - 1
+
+			file.hunks = append(file.hunks, hunk)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("patch has no ---/+++ file headers")
+	}
+
+	for _, file := range files {
+		if file.from == "" && file.to == "" {
+			return nil, errors.New("patch has a file with both sides /dev/null")
+		}
+	}
+
+	return files, nil
+}
+
+// parseHunk reads lines of the hunk starting at the given index and returns
+// the index of the first line after it.
+func parseHunk(lines []string, i int, hunk *patchHunk) int {
+	// oldLeft and newLeft are the lines of the header counts not read yet,
+	// counted lines are never trimmed as separators
+	oldLeft, newLeft := hunk.oldCount, hunk.newCount
+	counted := 0
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+
+		inCounts := oldLeft > 0 || newLeft > 0
+
+		if strings.HasPrefix(line, "@@") ||
+			strings.HasPrefix(line, "diff --git ") ||
+			isFileHeader(lines, i, inCounts, oldLeft, newLeft) {
+			break
+		}
+
+		if line == "" {
+			// context lines are often written without the leading space
+			hunk.lines = append(hunk.lines, patchLine{' ', ""})
+			oldLeft--
+			newLeft--
+
+			if inCounts {
+				counted = len(hunk.lines)
+			}
+
+			continue
+		}
+
+		if line[0] == '\\' {
+			if len(hunk.lines) > 0 {
+				switch hunk.lines[len(hunk.lines)-1].kind {
+				case '-':
+					hunk.oldEOF = true
+				case '+':
+					hunk.newEOF = true
+				default:
+					hunk.oldEOF = true
+					hunk.newEOF = true
+				}
+			}
+
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '-' && line[0] != '+' {
+			break
+		}
+
+		switch line[0] {
+		case ' ':
+			oldLeft--
+			newLeft--
+		case '-':
+			oldLeft--
+		case '+':
+			newLeft--
+		}
+
+		hunk.lines = append(hunk.lines, patchLine{line[0], line[1:]})
+
+		if inCounts {
+			counted = len(hunk.lines)
+		}
+	}
+
+	// empty lines at the end are the separators of hunks and files
+	end := len(hunk.lines)
+	for j := i - 1; end > counted && j >= 0 && lines[j] == ""; j-- {
+		end--
+	}
+
+	hunk.lines = hunk.lines[:end]
+
+	return i
+}
+
+// isFileHeader reports whether the line starts ---/+++ file headers. Inside
+// the header counts of the hunk such lines are removed and added lines,
+// unless the next hunk starts right after them and they don't complete
+// the counts, i.e. the counts are wrong.
+func isFileHeader(lines []string, i int, inCounts bool, oldLeft int, newLeft int) bool {
+	if !strings.HasPrefix(lines[i], "--- ") ||
+		i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+		return false
+	}
+
+	if !inCounts {
+		return true
+	}
+
+	if oldLeft == 1 && newLeft == 1 {
+		return false
+	}
+
+	return i+2 < len(lines) && strings.HasPrefix(lines[i+2], "@@")
+}
+
+// parseGitHeader returns names of the diff --git a/x b/y header, they are
+// used if the diff has no ---/+++ headers, e.g. for renames.
+func parseGitHeader(header string) (string, string) {
+	from, to, ok := strings.Cut(header, " b/")
+	if !ok {
+		return "", ""
+	}
+
+	return parsePatchName(from), parsePatchName("b/" + to)
+}
+
+// parsePatchName returns the path of the ---/+++ header without the
+// timestamp and the a/ or b/ prefix, empty for /dev/null.
+func parsePatchName(name string) string {
+	if tab := strings.IndexByte(name, '\t'); tab >= 0 {
+		name = name[:tab]
+	}
+
+	name = strings.TrimSpace(name)
+
+	if strings.HasPrefix(name, `"`) {
+		unquoted, err := strconv.Unquote(name)
+		if err == nil {
+			name = unquoted
+		}
+	}
+
+	if name == "/dev/null" {
+		return ""
+	}
+
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		name = name[2:]
+	}
+
+	return name
+}
+
+// patchText is a text file split into lines.
+type patchText struct {
+	lines []string
+
+	// eol is set if the last line ends with a newline
+	eol bool
+
+	// crlf is set if lines end with \r\n, the \r is stripped from lines
+	crlf bool
+}
+
+func splitPatchText(contents string) patchText {
+	text := patchText{eol: true}
+	if contents == "" {
+		return text
+	}
+
+	text.eol = strings.HasSuffix(contents, "\n")
+	text.lines = strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+
+	text.crlf = true
+	for i, line := range text.lines {
+		if !strings.HasSuffix(line, "\r") && (i < len(text.lines)-1 || text.eol) {
+			text.crlf = false
+			break
+		}
+	}
+
+	if text.crlf {
+		for i := range text.lines {
+			text.lines[i] = strings.TrimSuffix(text.lines[i], "\r")
+		}
+	}
+
+	return text
+}
+
+func (text patchText) String() string {
+	if len(text.lines) == 0 {
+		return ""
+	}
+
+	separator := "\n"
+	if text.crlf {
+		separator = "\r\n"
+	}
+
+	result := strings.Join(text.lines, separator)
+	if text.eol {
+		result += separator
+	}
+
+	return result
+}
+
+// applyHunks applies hunks in order, failed hunks are skipped and reported.
+func applyHunks(text patchText, hunks []patchHunk) (patchText, []PatchHunkResult) {
+	results := []PatchHunkResult{}
+
+	result := patchText{eol: text.eol, crlf: text.crlf}
+
+	// cursor is the first line of the original text not copied yet, delta
+	// is the difference of the actual and the declared position of the
+	// previous hunk
+	cursor, delta := 0, 0
+
+	for _, hunk := range hunks {
+		report := PatchHunkResult{Hunk: hunk.header}
+
+		match, ok := findHunk(text.lines, cursor, delta, hunk)
+		if !ok {
+			report.Error = "lines to remove and context are not found in the file"
+
+			if hint := hunkHint(text.lines, hunk); hint > 0 {
+				report.Error += fmt.Sprintf(
+					", the first of them is at line %d but the following differ",
+					hint,
+				)
+			}
+
+			report.Error += ", read the file with fs_read or use fs_edit"
+
+			results = append(results, report)
+			continue
+		}
+
+		result.lines = append(result.lines, text.lines[cursor:match.position]...)
+
+		position := match.position
+		for _, line := range hunk.lines[match.lead : len(hunk.lines)-match.trail] {
+			switch line.kind {
+			case ' ':
+				result.lines = append(result.lines, text.lines[position])
+				position++
+			case '-':
+				position++
+			case '+':
+				result.lines = append(result.lines, line.text)
+			}
+		}
+
+		if position == len(text.lines) {
+			if hunk.newEOF {
+				result.eol = false
+			} else if hunk.oldEOF || len(text.lines) == 0 {
+				result.eol = true
+			}
+		}
+
+		if hunk.oldStart > 0 {
+			delta = match.position - match.lead - hunk.start()
+		}
+
+		report.Applied = true
+		report.Line = match.position + 1
+		report.Offset = delta
+		report.Fuzz = match.fuzz
+		report.Whitespace = match.whitespace
+
+		if hunk.oldStart == 0 {
+			report.Offset = 0
+		}
+
+		results = append(results, report)
+
+		cursor = position
+	}
+
+	result.lines = append(result.lines, text.lines[cursor:]...)
+
+	return result, results
+}
+
+// start returns the zero-based line the hunk is expected at.
+func (hunk patchHunk) start() int {
+	// a hunk without old lines is inserted after the given line
+	if hunk.oldCount == 0 {
+		return hunk.oldStart
+	}
+
+	return hunk.oldStart - 1
+}
+
+type hunkMatch struct {
+	position int
+
+	// lead and trail are numbers of context lines ignored at each end
+	lead  int
+	trail int
+
+	fuzz       int
+	whitespace bool
+}
+
+// findHunk looks for the old lines of the hunk starting from the declared
+// position and moving away from it, then ignoring whitespace and then
+// ignoring context lines at the ends of the hunk.
+func findHunk(lines []string, cursor int, delta int, hunk patchHunk) (hunkMatch, bool) {
+	compare := []func(a, b string) bool{
+		func(a, b string) bool { return a == b },
+		func(a, b string) bool {
+			return strings.TrimRight(a, " \t") == strings.TrimRight(b, " \t")
+		},
+		func(a, b string) bool {
+			return strings.TrimSpace(a) == strings.TrimSpace(b)
+		},
+	}
+
+	expected := cursor
+	if hunk.oldStart > 0 {
+		expected = max(hunk.start()+delta, cursor)
+	}
+
+	for fuzz := 0; fuzz <= patchMaxFuzz; fuzz++ {
+		lead := min(fuzz, leadingContext(hunk.lines))
+		trail := min(fuzz, trailingContext(hunk.lines[lead:]))
+
+		if fuzz > 0 && lead+trail < fuzz {
+			// nothing else to ignore
+			break
+		}
+
+		var old []string
+		for _, line := range hunk.lines[lead : len(hunk.lines)-trail] {
+			if line.kind != '+' {
+				old = append(old, line.text)
+			}
+		}
+
+		if len(old) == 0 && fuzz > 0 {
+			break
+		}
+
+		for mode, equal := range compare {
+			position, ok := searchLines(lines, old, cursor, expected+lead, equal)
+			if ok {
+				return hunkMatch{
+					position:   position,
+					lead:       lead,
+					trail:      trail,
+					fuzz:       fuzz,
+					whitespace: mode > 0,
+				}, true
+			}
+		}
+	}
+
+	return hunkMatch{}, false
+}
+
+// searchLines finds the old lines not before the cursor, closest to the
+// expected position.
+func searchLines(
+	lines []string,
+	old []string,
+	cursor int,
+	expected int,
+	equal func(a, b string) bool,
+) (int, bool) {
+	last := len(lines) - len(old)
+	if last < cursor {
+		return 0, false
+	}
+
+	expected = min(max(expected, cursor), last)
+
+	matches := func(position int) bool {
+		for i, line := range old {
+			if !equal(lines[position+i], line) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for distance := 0; expected-distance >= cursor || expected+distance <= last; distance++ {
+		if position := expected - distance; position >= cursor && matches(position) {
+			return position, true
+		}
+
+		if position := expected + distance; distance > 0 && position <= last && matches(position) {
+			return position, true
+		}
+	}
+
+	return 0, false
+}
+
+func leadingContext(lines []patchLine) int {
+	count := 0
+	for count < len(lines) && lines[count].kind == ' ' {
+		count++
+	}
+
+	return count
+}
+
+func trailingContext(lines []patchLine) int {
+	count := 0
+	for count < len(lines) && lines[len(lines)-1-count].kind == ' ' {
+		count++
+	}
+
+	return count
+}
+
+// hunkHint returns the line where the first old line of the hunk is found,
+// zero if it's not found.
+func hunkHint(lines []string, hunk patchHunk) int {
+	for _, line := range hunk.lines {
+		if line.kind == '+' || strings.TrimSpace(line.text) == "" {
+			continue
+		}
+
+		for number, candidate := range lines {
+			if strings.TrimSpace(candidate) == strings.TrimSpace(line.text) {
+				return number + 1
+			}
+		}
+
+		break
+	}
+
+	return 0
+}
+
+// patchChange is the new state of a file changed by the patch.
+type patchChange struct {
+	// from is the original name, to is the new name, empty if the file is
+	// removed
+	from string
+	to   string
+
+	original []byte
+	existed  bool
+	text     patchText
+}
+
+func (dispatcher *Dispatcher) patchFile(args PatchFileArguments) (any, error) {
+	files, err := parsePatch(args.Patch)
+	if err != nil {
+		return nil, karma.Format(err, "parse patch")
+	}
+
+	for _, name := range args.Paths() {
+		_, err := dispatcher.sandboxWrite(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := PatchResult{Applied: true, Files: []PatchFileResult{}}
+
+	// changes are keyed by the current name of the file, so the same file
+	// may be changed by several parts of the patch
+	changes := map[string]*patchChange{}
+	order := []*patchChange{}
+
+	for _, file := range files {
+		report := PatchFileResult{Path: file.to, Status: "modified"}
+
+		switch {
+		case file.from == "":
+			report.Status = "created"
+		case file.to == "":
+			report.Path = file.from
+			report.Status = "removed"
+		case file.from != file.to:
+			report.From = file.from
+			report.Status = "renamed"
+		}
+
+		change, err := dispatcher.loadPatchChange(changes, file)
+		if err != nil {
+			report.Error = karma.Flatten(err).Error()
+		} else {
+			change.text, report.Hunks = applyHunks(change.text, file.hunks)
+
+			if file.to == "" && len(change.text.lines) > 0 {
+				report.Error = "file still has lines after the patch, it's not removed"
+			}
+		}
+
+		for _, hunk := range report.Hunks {
+			if !hunk.Applied {
+				result.Applied = false
+			}
+		}
+
+		if report.Error != "" {
+			result.Applied = false
+		}
+
+		result.Files = append(result.Files, report)
+
+		if change == nil {
+			continue
+		}
+
+		if !containsChange(order, change) {
+			order = append(order, change)
+		}
+
+		delete(changes, file.from)
+		if file.to != "" {
+			changes[file.to] = change
+		}
+	}
+
+	if !result.Applied {
+		return result, nil
+	}
+
+	err = dispatcher.writePatchChanges(order)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func containsChange(changes []*patchChange, change *patchChange) bool {
+	for _, item := range changes {
+		if item == change {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadPatchChange returns the change of the file the patch part is applied
+// to, the file is read from the workspace unless the patch changed it
+// already.
+func (dispatcher *Dispatcher) loadPatchChange(
+	changes map[string]*patchChange,
+	file *patchFile,
+) (*patchChange, error) {
+	if file.from == "" {
+		if change, ok := changes[file.to]; ok && change.to != "" {
+			return nil, fmt.Errorf("%s already exists", file.to)
+		}
+
+		_, err := dispatcher.readWorkspaceFile(file.to)
+		if err == nil {
+			return nil, fmt.Errorf("%s already exists", file.to)
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		return &patchChange{to: file.to, text: splitPatchText("")}, nil
+	}
+
+	change, ok := changes[file.from]
+	if !ok {
+		contents, err := dispatcher.readWorkspaceFile(file.from)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%s does not exist", file.from)
+			}
+
+			return nil, err
+		}
+
+		if isBinary(contents) {
+			return nil, fmt.Errorf("%s is a binary file", file.from)
+		}
+
+		change = &patchChange{
+			from:     file.from,
+			original: contents,
+			existed:  true,
+			text:     splitPatchText(string(contents)),
+		}
+	}
+
+	change.to = file.to
+
+	return change, nil
+}
+
+// writePatchChanges writes all changes, if one of them fails, the written
+// ones are reverted.
+func (dispatcher *Dispatcher) writePatchChanges(changes []*patchChange) error {
+	if dispatcher.overlay != nil {
+		for _, change := range changes {
+			err := dispatcher.writeOverlayPatchChange(change)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	paths := []string{}
+	for _, change := range changes {
+		for _, name := range []string{change.from, change.to} {
+			if name == "" {
+				continue
+			}
+
+			path, err := dispatcher.sandboxWrite(name)
+			if err != nil {
+				return err
+			}
+
+			paths = append(paths, path)
+		}
+	}
+
+	err := dispatcher.checkpoint(paths...)
+	if err != nil {
+		return err
+	}
+
+	var reverts []func() error
+	for _, change := range changes {
+		revert, err := dispatcher.writePatchChange(change)
+		if err == nil {
+			reverts = append(reverts, revert)
+			continue
+		}
+
+		for i := len(reverts) - 1; i >= 0; i-- {
+			revertErr := reverts[i]()
+			if revertErr != nil {
+				log.Println(karma.Format(revertErr, "revert patch"))
+			}
+		}
+
+		return karma.Format(err, "apply patch, written files are reverted")
+	}
+
+	return nil
+}
+
+// writePatchChange writes the change to the workspace and returns the
+// function reverting it.
+func (dispatcher *Dispatcher) writePatchChange(change *patchChange) (func() error, error) {
+	mode := os.FileMode(0644)
+	if change.existed {
+		path, err := dispatcher.sandbox(change.from)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, karma.Format(err, "stat file: %s", change.from)
+		}
+
+		mode = info.Mode().Perm()
+	}
+
+	revert := func() error {
+		if change.to != "" && change.to != change.from {
+			err := dispatcher.removePatchFile(change.to)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if change.existed {
+			return dispatcher.writePatchFile(change.from, change.original, mode)
+		}
+
+		return nil
+	}
+
+	if change.to != "" {
+		err := dispatcher.writePatchFile(change.to, []byte(change.text.String()), mode)
+		if err != nil {
+			return nil, errors.Join(err, revert())
+		}
+	}
+
+	if change.existed && change.to != change.from {
+		err := dispatcher.removePatchFile(change.from)
+		if err != nil {
+			return nil, errors.Join(err, revert())
+		}
+	}
+
+	return revert, nil
+}
+
+func (dispatcher *Dispatcher) writePatchFile(name string, contents []byte, mode os.FileMode) error {
+	path, err := dispatcher.sandboxWrite(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return karma.Format(
+			relativeError(dispatcher.cwd, err),
+			"create directory: %s", filepath.Dir(name),
+		)
+	}
+
+	fd, err := dispatcher.open(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return karma.Format(err, "open file: %s", name)
+	}
+
+	defer fd.Close()
+
+	_, err = fd.Write(contents)
+	if err != nil {
+		return karma.Format(err, "write file: %s", name)
+	}
+
+	return nil
+}
+
+func (dispatcher *Dispatcher) removePatchFile(name string) error {
+	path, err := dispatcher.sandboxWrite(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (dispatcher *Dispatcher) writeOverlayPatchChange(change *patchChange) error {
+	switch {
+	case change.to == "" && !change.existed:
+		return nil
+
+	case change.to == "":
+		return dispatcher.overlay.Remove(change.from)
+
+	case change.existed && change.from != change.to:
+		err := dispatcher.overlay.Move(change.from, change.to)
+		if err != nil {
+			return err
+		}
+	}
+
+	return dispatcher.overlay.Write(change.to, []byte(change.text.String()), false)
+}
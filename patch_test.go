@@ -0,0 +1,270 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDispatcher_PatchFile(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	files := map[string]string{
+		"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(1)\n}\n\n" +
+			"func other() {\n\tfmt.Println(2)\n}\n",
+		"old.txt":    "one\ntwo\n",
+		"gone.txt":   "bye\n",
+		"dos.txt":    "a\r\nb\r\n",
+		"no_eol.txt": "first\nlast",
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dispatcher.cwd, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(name string) string {
+		contents, err := os.ReadFile(filepath.Join(dispatcher.cwd, name))
+		if os.IsNotExist(err) {
+			return "<none>"
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(contents)
+	}
+
+	// wrong line numbers and counts, missing leading space of the empty
+	// context line and changed indentation are tolerated
+	patch := strings.Join([]string{
+		"diff --git a/main.go b/main.go",
+		"--- a/main.go",
+		"+++ b/main.go",
+		"@@ -1,2 +1,2 @@",
+		" func main() {",
+		"-    fmt.Println(1)",
+		"+\tfmt.Println(10)",
+		"@@ -30,3 +30,3 @@",
+		" }",
+		"",
+		" func other() {",
+		"-\tfmt.Println(2)",
+		"+\tfmt.Println(20)",
+		" }",
+		"diff --git a/old.txt b/new.txt",
+		"similarity index 50%",
+		"rename from old.txt",
+		"rename to new.txt",
+		"--- a/old.txt",
+		"+++ b/new.txt",
+		"@@ -1,2 +1,2 @@",
+		" one",
+		"-two",
+		"+three",
+		"diff --git a/gone.txt b/gone.txt",
+		"deleted file mode 100644",
+		"--- a/gone.txt",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-bye",
+		"--- /dev/null",
+		"+++ b/dir/created.txt",
+		"@@ -0,0 +1 @@",
+		"+created",
+		"\\ No newline at end of file",
+		"--- a/dos.txt",
+		"+++ b/dos.txt",
+		"@@ -2 +2 @@",
+		"-b",
+		"+c",
+		"--- a/no_eol.txt",
+		"+++ b/no_eol.txt",
+		"@@ -1,2 +1,2 @@",
+		" first",
+		"-last",
+		"\\ No newline at end of file",
+		"+last",
+		"",
+	}, "\n")
+
+	result, err := dispatcher.patchFile(PatchFileArguments{Patch: patch})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := result.(PatchResult)
+	if !report.Applied {
+		t.Fatalf("expected the patch to be applied: %+v", report)
+	}
+
+	hunks := report.Files[0].Hunks
+	if len(hunks) != 2 || !hunks[0].Whitespace || hunks[0].Line != 5 ||
+		hunks[1].Line != 7 || hunks[1].Fuzz != 0 {
+		t.Errorf("unexpected hunks of main.go: %+v", hunks)
+	}
+
+	statuses := []string{}
+	for _, file := range report.Files {
+		statuses = append(statuses, file.Path+":"+file.Status)
+	}
+
+	expectedStatuses := []string{
+		"main.go:modified", "new.txt:renamed", "gone.txt:removed",
+		"dir/created.txt:created", "dos.txt:modified", "no_eol.txt:modified",
+	}
+	if !reflect.DeepEqual(statuses, expectedStatuses) {
+		t.Errorf("unexpected statuses: %v", statuses)
+	}
+
+	expected := map[string]string{
+		"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(10)\n}\n\n" +
+			"func other() {\n\tfmt.Println(20)\n}\n",
+		"old.txt":         "<none>",
+		"new.txt":         "one\nthree\n",
+		"gone.txt":        "<none>",
+		"dir/created.txt": "created",
+		"dos.txt":         "a\r\nc\r\n",
+		"no_eol.txt":      "first\nlast\n",
+	}
+	for name, contents := range expected {
+		if read(name) != contents {
+			t.Errorf("%s: expected %q, got %q", name, contents, read(name))
+		}
+	}
+
+	// the second hunk fails, so the first file is not changed either
+	patch = strings.Join([]string{
+		"--- a/new.txt",
+		"+++ b/new.txt",
+		"@@ -1 +1 @@",
+		"-one",
+		"+uno",
+		"--- a/main.go",
+		"+++ b/main.go",
+		"@@ -1 +1 @@",
+		"-package other",
+		"+package main",
+	}, "\n")
+
+	result, err = dispatcher.patchFile(PatchFileArguments{Patch: patch})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report = result.(PatchResult)
+	if report.Applied || !report.Files[0].Hunks[0].Applied || report.Files[1].Hunks[0].Applied ||
+		!strings.Contains(report.Files[1].Hunks[0].Error, "not found") {
+		t.Errorf("expected the second file to fail: %+v", report)
+	}
+
+	if read("new.txt") != "one\nthree\n" {
+		t.Errorf("new.txt: expected to be unchanged, got %q", read("new.txt"))
+	}
+
+	_, err = dispatcher.patchFile(PatchFileArguments{
+		Patch: "--- a/../escape.txt\n+++ b/../escape.txt\n@@ -0,0 +1 @@\n+x\n",
+	})
+	if err == nil {
+		t.Error("expected paths outside of the workspace to be rejected")
+	}
+}
+
+func TestApplyHunks_Fuzz(t *testing.T) {
+	text := splitPatchText("a\nb\nc\nd\ne\n")
+
+	hunks := []patchHunk{{
+		header:   "@@ -2,3 +2,3 @@",
+		oldStart: 2,
+		oldCount: 3,
+		lines: []patchLine{
+			{' ', "x"},
+			{'-', "c"},
+			{'+', "C"},
+			{' ', "d"},
+		},
+	}}
+
+	result, reports := applyHunks(text, hunks)
+	if result.String() != "a\nb\nC\nd\ne\n" {
+		t.Errorf("unexpected result: %q", result.String())
+	}
+
+	if !reports[0].Applied || reports[0].Fuzz != 1 || reports[0].Line != 3 {
+		t.Errorf("unexpected report: %+v", reports[0])
+	}
+}
+
+func TestParsePatch_CommentLines(t *testing.T) {
+	// removed and added lines of SQL comments look like ---/+++ file
+	// headers, the counts of the hunk header tell them apart
+	patch := strings.Join([]string{
+		"--- a/schema.sql",
+		"+++ b/schema.sql",
+		"@@ -1,3 +1,3 @@",
+		" CREATE TABLE t (a INT);",
+		"--- old comment",
+		"+-- new comment",
+		" SELECT 1;",
+		"--- a/query.sql",
+		"+++ b/query.sql",
+		"@@ -1,2 +1,2 @@",
+		" SELECT 2;",
+		"--- old comment",
+		"+++ new comment",
+		"",
+	}, "\n")
+
+	files, err := parsePatch(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 || files[0].to != "schema.sql" || files[1].to != "query.sql" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	expected := []patchLine{
+		{' ', "SELECT 2;"},
+		{'-', "-- old comment"},
+		{'+', "++ new comment"},
+	}
+	if !reflect.DeepEqual(files[1].hunks[0].lines, expected) {
+		t.Errorf("unexpected lines: %+v", files[1].hunks[0].lines)
+	}
+
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	contents := map[string]string{
+		"schema.sql": "CREATE TABLE t (a INT);\n-- old comment\nSELECT 1;\n",
+		"query.sql":  "SELECT 2;\n-- old comment\n",
+	}
+	for name, text := range contents {
+		err := os.WriteFile(filepath.Join(dispatcher.cwd, name), []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := dispatcher.patchFile(PatchFileArguments{Patch: patch})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report := result.(PatchResult); !report.Applied || len(report.Files) != 2 {
+		t.Fatalf("expected the patch to be applied: %+v", report)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dispatcher.cwd, "query.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "SELECT 2;\n++ new comment\n" {
+		t.Errorf("unexpected query.sql: %q", data)
+	}
+}
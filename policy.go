@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// PolicyRule allows or denies tools matching the glob, optionally only for
+// the given paths. A call is denied if any deny rule matches it. If there
+// are allow rules for the tool, every path of the call must be allowed by
+// one of them.
+//
+//	rules:
+//	  - {action: deny, tool: "*", paths: ["*.env", ".git/**"]}
+//	  - {action: allow, tool: fs_write, paths: ["src/**"]}
+//	  - {action: deny, tool: sql_exec, paths: [prod.db], reason: production}
+type PolicyRule struct {
+	Action string   `yaml:"action" json:"action"`
+	Tool   string   `yaml:"tool" json:"tool"`
+	Paths  []string `yaml:"paths,omitempty" json:"paths,omitempty"`
+	Reason string   `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+type Policy struct {
+	// root is the workspace with resolved symlinks, paths are matched as
+	// given and as the files they resolve to
+	root  string
+	rules []PolicyRule
+}
+
+// PolicyDenial is returned to the model as a structured tool error.
+type PolicyDenial struct {
+	Error  string      `json:"error"`
+	Tool   string      `json:"tool"`
+	Path   string      `json:"path,omitempty"`
+	Rule   *PolicyRule `json:"rule,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// PolicyError wraps the denial to be passed as an error.
+type PolicyError struct {
+	Denial PolicyDenial
+}
+
+func (err *PolicyError) Error() string {
+	message := fmt.Sprintf("%s call is denied by policy", err.Denial.Tool)
+	if err.Denial.Path != "" {
+		message += fmt.Sprintf(" for path %s", err.Denial.Path)
+	}
+
+	if err.Denial.Reason != "" {
+		message += ": " + err.Denial.Reason
+	}
+
+	return message
+}
+
+// PathArguments are implemented by tool arguments that refer to paths of
+// the workspace, so the policy can check them.
+type PathArguments interface {
+	Paths() []string
+}
+
+func NewPolicy(root string, rules []PolicyRule) (*Policy, error) {
+	for i, rule := range rules {
+		if rule.Action != PolicyAllow && rule.Action != PolicyDeny {
+			return nil, fmt.Errorf(
+				"rule #%d: action must be %q or %q, got %q",
+				i+1, PolicyAllow, PolicyDeny, rule.Action,
+			)
+		}
+
+		if rule.Tool == "" {
+			rules[i].Tool = "*"
+		}
+
+		for _, pattern := range rule.Paths {
+			err := checkGlob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule #%d: invalid pattern %q: %s", i+1, pattern, err)
+			}
+		}
+	}
+
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, karma.Format(err, "resolve workspace")
+	}
+
+	return &Policy{root: resolved, rules: rules}, nil
+}
+
+// Check returns an error if the call of the tool with the given paths is
+// not allowed.
+func (policy *Policy) Check(tool string, paths []string) error {
+	if policy == nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, name := range paths {
+		names = append(names, policy.resolve(name)...)
+	}
+
+	paths = names
+
+	hasAllow := false
+	for i, rule := range policy.rules {
+		matched, _ := path.Match(rule.Tool, tool)
+		if !matched {
+			continue
+		}
+
+		if rule.Action == PolicyAllow {
+			hasAllow = true
+			continue
+		}
+
+		if len(rule.Paths) == 0 {
+			return policy.deny(tool, "", &policy.rules[i])
+		}
+
+		for _, name := range paths {
+			if rule.matchPath(name) {
+				return policy.deny(tool, name, &policy.rules[i])
+			}
+		}
+	}
+
+	if !hasAllow {
+		return nil
+	}
+
+	for _, name := range paths {
+		if !policy.allowed(tool, name) {
+			return policy.deny(tool, name, nil)
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the cleaned path and the path of the file it resolves
+// to like the sandbox resolves it, e.g. a symlink x.txt to prod.env is
+// matched as prod.env too.
+func (policy *Policy) resolve(name string) []string {
+	name = filepath.Clean(name)
+
+	names := []string{filepath.ToSlash(name)}
+	if filepath.IsAbs(name) || !isLocal(name) {
+		return names
+	}
+
+	// paths that can't be resolved are rejected by the sandbox
+	resolved, err := resolvePath(policy.root, name)
+	if err != nil {
+		return names
+	}
+
+	relative, err := filepath.Rel(policy.root, resolved)
+	if err != nil || !isLocal(relative) {
+		return names
+	}
+
+	if relative = filepath.ToSlash(relative); relative != names[0] {
+		names = append(names, relative)
+	}
+
+	return names
+}
+
+func (policy *Policy) allowed(tool string, name string) bool {
+	for _, rule := range policy.rules {
+		if rule.Action != PolicyAllow {
+			continue
+		}
+
+		matched, _ := path.Match(rule.Tool, tool)
+		if !matched {
+			continue
+		}
+
+		if len(rule.Paths) == 0 || rule.matchPath(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (policy *Policy) deny(tool string, name string, rule *PolicyRule) error {
+	denial := PolicyDenial{
+		Error: "denied_by_policy",
+		Tool:  tool,
+		Path:  name,
+		Rule:  rule,
+	}
+
+	if rule != nil {
+		denial.Reason = rule.Reason
+	} else {
+		denial.Reason = "the path is not allowed by any rule for the tool"
+	}
+
+	return &PolicyError{Denial: denial}
+}
+
+func (rule PolicyRule) matchPath(name string) bool {
+	for _, pattern := range rule.Paths {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matched bool
+	}{
+		{"*.env", ".env", true},
+		{"*.env", "config/prod.env", true},
+		{"*.env", "env", false},
+		{"src/**", "src", true},
+		{"src/**", "src/main.go", true},
+		{"src/**", "src/pkg/main.go", true},
+		{"src/**", "srcs/main.go", false},
+		{".git/**", ".git/config", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/aight/main.go", true},
+		{"cmd/*/main.go", "cmd/aight/main.go", true},
+		{"cmd/*/main.go", "cmd/a/b/main.go", false},
+		{"prod.db", "./prod.db", true},
+		{"/prod.db", "prod.db", true},
+	}
+
+	for _, test := range tests {
+		matched := matchGlob(test.pattern, test.name)
+		if matched != test.matched {
+			t.Errorf("%q ~ %q: expected %v, got %v", test.pattern, test.name, test.matched, matched)
+		}
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy, err := NewPolicy(t.TempDir(), []PolicyRule{
+		{Action: PolicyDeny, Tool: "*", Paths: []string{"*.env", ".git/**"}},
+		{Action: PolicyDeny, Tool: "sql_exec", Paths: []string{"prod.db"}, Reason: "production"},
+		{Action: PolicyDeny, Tool: "python_*"},
+		{Action: PolicyAllow, Tool: "fs_write", Paths: []string{"src/**"}},
+		{Action: PolicyAllow, Tool: "fs_move", Paths: []string{"src/**", "docs/**"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tool    string
+		paths   []string
+		allowed bool
+	}{
+		{"fs_read", []string{"main.go"}, true},
+		{"fs_read", []string{".env"}, false},
+		{"fs_list", []string{".git/refs/heads"}, false},
+		{"sql_exec", []string{"prod.db"}, false},
+		{"sql_query", []string{"prod.db"}, true},
+		{"python_execute", nil, false},
+		{"fs_write", []string{"src/main.go"}, true},
+		{"fs_write", []string{"src/../main.go"}, false},
+		{"fs_write", []string{"main.go"}, false},
+		{"fs_write", []string{"src/.env"}, false},
+		{"fs_move", []string{"src/a.go", "docs/a.go"}, true},
+		{"fs_move", []string{"src/a.go", "a.go"}, false},
+	}
+
+	for _, test := range tests {
+		err := policy.Check(test.tool, test.paths)
+		if (err == nil) != test.allowed {
+			t.Errorf("%s %v: expected allowed %v, got %v", test.tool, test.paths, test.allowed, err)
+		}
+	}
+
+	var nothing *Policy
+	if err := nothing.Check("fs_remove", []string{".env"}); err != nil {
+		t.Errorf("nil policy must allow everything, got %v", err)
+	}
+}
+
+func TestPolicy_CheckResolved(t *testing.T) {
+	root := t.TempDir()
+
+	writeFiles(t, root, map[string]string{
+		"prod.env":     "TOKEN=secret\n",
+		"sub/main.go":  "package main\n",
+		"src/main.go":  "package main\n",
+		"docs/note.md": "note\n",
+	})
+
+	links := map[string]string{
+		"x.txt":       "prod.env",
+		"dir":         "sub",
+		"src/outside": "../docs/note.md",
+	}
+	for name, target := range links {
+		err := os.Symlink(target, filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy, err := NewPolicy(root, []PolicyRule{
+		{Action: PolicyDeny, Tool: "fs_read", Paths: []string{"*.env"}},
+		{Action: PolicyAllow, Tool: "fs_write", Paths: []string{"src/**"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tool    string
+		path    string
+		allowed bool
+	}{
+		{"fs_read", "x.txt", false},
+		{"fs_read", "./prod.env", false},
+		{"fs_read", "sub/../prod.env", false},
+		{"fs_read", "dir/../x.txt", false},
+		{"fs_read", "dir/main.go", true},
+		{"fs_write", "src/new.go", true},
+		{"fs_write", "./src/main.go", true},
+		{"fs_write", "src/outside", false},
+	}
+	for _, test := range tests {
+		err := policy.Check(test.tool, []string{test.path})
+		if (err == nil) != test.allowed {
+			t.Errorf("%s %s: expected allowed %v, got %v", test.tool, test.path, test.allowed, err)
+		}
+	}
+}
+
+func TestNewPolicy_Invalid(t *testing.T) {
+	_, err := NewPolicy(t.TempDir(), []PolicyRule{{Action: "maybe", Tool: "*"}})
+	if err == nil {
+		t.Error("expected error for unknown action")
+	}
+
+	_, err = NewPolicy(t.TempDir(), []PolicyRule{{Action: PolicyDeny, Paths: []string{"[a-"}}})
+	if err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestPatchFileArguments_Paths(t *testing.T) {
+	args := PatchFileArguments{
+		Patch: "--- a/src/main.go\t2024-01-01\n+++ b/src/main.go\n@@ -1 +1 @@\n-a\n+b\n" +
+			"--- /dev/null\n+++ b/docs/new.md\n@@ -0,0 +1 @@\n+new\n",
+	}
+
+	expected := []string{"src/main.go", "src/main.go", "docs/new.md"}
+
+	paths := args.Paths()
+	if len(paths) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, paths)
+		}
+	}
+}
+
+func TestDispatcher_Policy(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	var err error
+	dispatcher.policy, err = NewPolicy(dispatcher.cwd, []PolicyRule{
+		{Action: PolicyDeny, Tool: "fs_*", Paths: []string{"*.env"}, Reason: "secrets"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dispatcher.handleToolCalls([]ToolUse{
+		{ID: "read", Name: "fs_read", Input: []byte(`{"path":"config/.env"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := dispatcher.thread[0].Content[0].ToolResult
+	if !result.IsError {
+		t.Fatalf("expected error result, got %#v", result)
+	}
+
+	var denial PolicyDenial
+	err = json.Unmarshal([]byte(result.Content), &denial)
+	if err != nil {
+		t.Fatalf("expected structured denial, got %q", result.Content)
+	}
+
+	if denial.Error != "denied_by_policy" || denial.Tool != "fs_read" ||
+		denial.Path != "config/.env" || denial.Reason != "secrets" {
+		t.Errorf("unexpected denial: %#v", denial)
+	}
+}
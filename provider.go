@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+type ContentType string
+
+const (
+	ContentTypeText       ContentType = "text"
+	ContentTypeToolUse    ContentType = "tool_use"
+	ContentTypeToolResult ContentType = "tool_result"
+)
+
+// Message is a provider-neutral message of the thread, every provider
+// converts it to its own wire format.
+type Message struct {
+	Role    string    `json:"role"`
+	Content []Content `json:"content"`
+}
+
+type Content struct {
+	Type ContentType `json:"type"`
+
+	Text string `json:"text,omitempty"`
+
+	ToolUse    *ToolUse    `json:"tool_use,omitempty"`
+	ToolResult *ToolResult `json:"tool_result,omitempty"`
+}
+
+type ToolUse struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type ToolResult struct {
+	ToolUseID string `json:"tool_use_id"`
+	Content   string `json:"content"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+// UnmarshalJSON decodes both the current layout and the flat Anthropic
+// layout of threads saved before the provider-neutral format, where the
+// tool fields are placed at the top level of the block.
+func (content *Content) UnmarshalJSON(data []byte) error {
+	type layout Content
+
+	var current layout
+	err := json.Unmarshal(data, &current)
+	if err != nil {
+		return err
+	}
+
+	*content = Content(current)
+
+	switch {
+	case content.Type == ContentTypeToolUse && content.ToolUse == nil:
+		var legacy struct {
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		}
+
+		err := json.Unmarshal(data, &legacy)
+		if err != nil {
+			return err
+		}
+
+		if legacy.ID != "" || legacy.Name != "" {
+			if len(legacy.Input) == 0 {
+				legacy.Input = json.RawMessage("{}")
+			}
+
+			content.ToolUse = &ToolUse{
+				ID:    legacy.ID,
+				Name:  legacy.Name,
+				Input: legacy.Input,
+			}
+		}
+
+	case content.Type == ContentTypeToolResult && content.ToolResult == nil:
+		var legacy struct {
+			ToolUseID string          `json:"tool_use_id"`
+			Content   json.RawMessage `json:"content"`
+			IsError   bool            `json:"is_error"`
+		}
+
+		err := json.Unmarshal(data, &legacy)
+		if err != nil {
+			return err
+		}
+
+		if legacy.ToolUseID != "" {
+			text, err := decodeLegacyResult(legacy.Content)
+			if err != nil {
+				return err
+			}
+
+			content.ToolResult = &ToolResult{
+				ToolUseID: legacy.ToolUseID,
+				Content:   text,
+				IsError:   legacy.IsError,
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeLegacyResult joins the text blocks of a legacy tool result, the
+// content is either a plain string or a list of text blocks.
+func decodeLegacyResult(data json.RawMessage) (string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		return text, nil
+	}
+
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+
+	err := json.Unmarshal(data, &blocks)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode tool result content: %w", err)
+	}
+
+	texts := []string{}
+	for _, block := range blocks {
+		if block.Type == string(ContentTypeText) {
+			texts = append(texts, block.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n"), nil
+}
+
+// validate reports blocks that can't be sent to a provider, e.g. tool
+// blocks of a damaged thread that have no payload.
+func (content Content) validate() error {
+	switch content.Type {
+	case ContentTypeToolUse:
+		if content.ToolUse == nil {
+			return errors.New("tool_use block has no tool call")
+		}
+	case ContentTypeToolResult:
+		if content.ToolResult == nil {
+			return errors.New("tool_result block has no tool result")
+		}
+	}
+
+	return nil
+}
+
+func NewTextContent(text string) Content {
+	return Content{
+		Type: ContentTypeText,
+		Text: text,
+	}
+}
+
+func NewToolUseContent(id string, name string, input json.RawMessage) Content {
+	return Content{
+		Type: ContentTypeToolUse,
+		ToolUse: &ToolUse{
+			ID:    id,
+			Name:  name,
+			Input: input,
+		},
+	}
+}
+
+func NewToolResultContent(toolUseID string, content string, isError bool) Content {
+	return Content{
+		Type: ContentTypeToolResult,
+		ToolResult: &ToolResult{
+			ToolUseID: toolUseID,
+			Content:   content,
+			IsError:   isError,
+		},
+	}
+}
+
+func NewTextMessage(role string, text string) Message {
+	return Message{
+		Role:    role,
+		Content: []Content{NewTextContent(text)},
+	}
+}
+
+type ToolDefinition struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	InputSchema *jsonschema.Schema `json:"input_schema"`
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type CompletionRequest struct {
+	Model     string
+	System    string
+	Messages  []Message
+	Tools     []ToolDefinition
+	MaxTokens int
+
+	// Temperature is the default of the provider if not set
+	Temperature *float64
+
+	// Stream receives the completion while it is being assembled, providers
+	// that don't support streaming ignore it.
+	Stream StreamHandler
+}
+
+type Completion struct {
+	Content    []Content
+	Usage      Usage
+	StopReason string
+
+	// Streamed is true if the content was already passed to the stream
+	// handler.
+	Streamed bool
+}
+
+func (completion *Completion) ToolUses() []ToolUse {
+	var toolUses []ToolUse
+	for _, content := range completion.Content {
+		if content.Type == ContentTypeToolUse && content.ToolUse != nil {
+			toolUses = append(toolUses, *content.ToolUse)
+		}
+	}
+
+	return toolUses
+}
+
+// ErrNoMoreCompletions is returned by providers that will never be able to
+// complete the thread again.
+var ErrNoMoreCompletions = errors.New("no more completions")
+
+// StatusError is an error response of the provider API.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("api error (status %d): %s", err.StatusCode, err.Message)
+}
+
+// isRetryable reports whether the request may succeed if it's sent again:
+// the connection failed, or the API is rate limited or failed itself.
+// Other errors, e.g. of a bad token, model or request, fail the same way.
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.StatusCode)
+	}
+
+	var requestErr *anthropic.RequestError
+	if errors.As(err, &requestErr) {
+		return isRetryableStatus(requestErr.StatusCode)
+	}
+
+	var apiErr *anthropic.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.IsRateLimitErr() || apiErr.IsOverloadedErr() || apiErr.IsApiErr()
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr) ||
+		errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// Provider is a backend that is able to complete the thread.
+type Provider interface {
+	Complete(ctx context.Context, request CompletionRequest) (*Completion, error)
+}
+
+type ProviderOptions struct {
+	Name    string
+	BaseURL string
+	Token   string
+	Script  string
+
+	// HTTPClient is used by network providers if set, e.g. for recording
+	// and replaying the traffic.
+	HTTPClient *http.Client
+}
+
+// NewProvider creates a provider by its name. The token is read from the
+// environment variable if it starts with $, empty token means the default
+// variable of the provider.
+func NewProvider(options ProviderOptions) (Provider, error) {
+	switch options.Name {
+	case "anthropic":
+		value, err := resolveToken(options.Token, "$ANTHROPIC_API_KEY", true)
+		if err != nil {
+			return nil, err
+		}
+
+		clientOptions := []anthropic.ClientOption{}
+		if options.BaseURL != "" {
+			clientOptions = append(clientOptions, anthropic.WithBaseURL(options.BaseURL))
+		}
+
+		if options.HTTPClient != nil {
+			clientOptions = append(clientOptions, anthropic.WithHTTPClient(options.HTTPClient))
+		}
+
+		return NewAnthropicProvider(value, clientOptions...), nil
+
+	case "openai":
+		// local servers like llama.cpp don't require any token
+		value, err := resolveToken(options.Token, "$OPENAI_API_KEY", false)
+		if err != nil {
+			return nil, err
+		}
+
+		provider := NewOpenAIProvider(options.BaseURL, value)
+		if options.HTTPClient != nil {
+			provider.client = options.HTTPClient
+		}
+
+		return provider, nil
+
+	case "script":
+		if options.Script == "" {
+			return nil, errors.New("script provider requires --script <path>")
+		}
+
+		return NewScriptProvider(options.Script)
+
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", options.Name)
+	}
+}
+
+func resolveToken(token string, fallback string, required bool) (string, error) {
+	if token == "" {
+		token = fallback
+	}
+
+	if !strings.HasPrefix(token, "$") {
+		return token, nil
+	}
+
+	value := os.Getenv(token[1:])
+	if value == "" && required {
+		return "", fmt.Errorf(
+			"the environment variable %s is not set. "+
+				"Specify the environment value or pass it via --token flag.",
+			token,
+		)
+	}
+
+	return value, nil
+}
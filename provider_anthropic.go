@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+type AnthropicProvider struct {
+	client *anthropic.Client
+}
+
+func NewAnthropicProvider(token string, options ...anthropic.ClientOption) *AnthropicProvider {
+	return &AnthropicProvider{
+		client: anthropic.NewClient(token, options...),
+	}
+}
+
+func (provider *AnthropicProvider) Complete(
+	ctx context.Context,
+	request CompletionRequest,
+) (*Completion, error) {
+	messages, err := toAnthropicMessages(request.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	tools := []anthropic.ToolDefinition{}
+	for _, tool := range request.Tools {
+		tools = append(tools, anthropic.ToolDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.InputSchema,
+		})
+	}
+
+	payload := anthropic.MessagesRequest{
+		Model:     request.Model,
+		System:    request.System,
+		Messages:  messages,
+		MaxTokens: request.MaxTokens,
+		Tools:     tools,
+	}
+
+	if request.Temperature != nil {
+		payload.SetTemperature(float32(*request.Temperature))
+	}
+
+	if request.Stream != nil {
+		return provider.stream(ctx, payload, request.Stream)
+	}
+
+	response, err := provider.client.CreateMessages(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromAnthropicResponse(response), nil
+}
+
+func (provider *AnthropicProvider) stream(
+	ctx context.Context,
+	payload anthropic.MessagesRequest,
+	handler StreamHandler,
+) (*Completion, error) {
+	response, err := provider.client.CreateMessagesStream(ctx, anthropic.MessagesStreamRequest{
+		MessagesRequest: payload,
+
+		OnContentBlockStart: func(data anthropic.MessagesEventContentBlockStartData) {
+			block := data.ContentBlock
+			if block.Type == anthropic.MessagesContentTypeToolUse && block.MessageContentToolUse != nil {
+				handler.ToolUse(block.MessageContentToolUse.ID, block.MessageContentToolUse.Name)
+			}
+		},
+
+		OnContentBlockDelta: func(data anthropic.MessagesEventContentBlockDeltaData) {
+			switch data.Delta.Type {
+			case anthropic.MessagesContentTypeTextDelta:
+				handler.Text(data.Delta.GetText())
+			case anthropic.MessagesContentTypeInputJsonDelta:
+				if data.Delta.PartialJson != nil {
+					handler.ToolInput(*data.Delta.PartialJson)
+				}
+			}
+		},
+
+		OnContentBlockStop: func(anthropic.MessagesEventContentBlockStopData, anthropic.MessageContent) {
+			handler.Stop()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	completion := fromAnthropicResponse(response)
+	completion.Streamed = true
+
+	return completion, nil
+}
+
+func toAnthropicMessages(thread []Message) ([]anthropic.Message, error) {
+	messages := []anthropic.Message{}
+	for index, message := range thread {
+		content := []anthropic.MessageContent{}
+		for _, block := range message.Content {
+			err := block.validate()
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %w", index, err)
+			}
+
+			switch block.Type {
+			case ContentTypeText:
+				content = append(content, anthropic.NewTextMessageContent(block.Text))
+			case ContentTypeToolUse:
+				content = append(
+					content,
+					anthropic.NewToolUseMessageContent(
+						block.ToolUse.ID,
+						block.ToolUse.Name,
+						block.ToolUse.Input,
+					),
+				)
+			case ContentTypeToolResult:
+				content = append(
+					content,
+					anthropic.NewToolResultMessageContent(
+						block.ToolResult.ToolUseID,
+						block.ToolResult.Content,
+						block.ToolResult.IsError,
+					),
+				)
+			default:
+				return nil, fmt.Errorf("unexpected content type: %s", block.Type)
+			}
+		}
+
+		messages = append(messages, anthropic.Message{
+			Role:    message.Role,
+			Content: content,
+		})
+	}
+
+	return messages, nil
+}
+
+func fromAnthropicResponse(response anthropic.MessagesResponse) *Completion {
+	completion := &Completion{
+		StopReason: string(response.StopReason),
+		Usage: Usage{
+			InputTokens:  response.Usage.InputTokens,
+			OutputTokens: response.Usage.OutputTokens,
+		},
+	}
+
+	for _, block := range response.Content {
+		switch block.Type {
+		case anthropic.MessagesContentTypeText:
+			completion.Content = append(completion.Content, NewTextContent(block.GetText()))
+		case anthropic.MessagesContentTypeToolUse:
+			input := block.MessageContentToolUse.Input
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+
+			completion.Content = append(
+				completion.Content,
+				NewToolUseContent(
+					block.MessageContentToolUse.ID,
+					block.MessageContentToolUse.Name,
+					input,
+				),
+			)
+		}
+	}
+
+	return completion
+}
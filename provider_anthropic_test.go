@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+func sse(events ...string) string {
+	var buffer strings.Builder
+	for i := 0; i < len(events); i += 2 {
+		fmt.Fprintf(&buffer, "event: %s\ndata: %s\n\n", events[i], events[i+1])
+	}
+
+	return buffer.String()
+}
+
+func TestAnthropicProvider_Stream(t *testing.T) {
+	color.NoColor = true
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			if request.URL.Path != "/messages" {
+				t.Errorf("unexpected path: %s", request.URL.Path)
+			}
+
+			writer.Header().Set("Content-Type", "text/event-stream")
+
+			fmt.Fprint(writer, sse(
+				"message_start", `{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":10,"output_tokens":1}}}`,
+				"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}`,
+				"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"look."}}`,
+				"content_block_stop", `{"type":"content_block_stop","index":0}`,
+				"content_block_start", `{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"fs_list","input":{}}}`,
+				"content_block_delta", `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}`,
+				"content_block_delta", `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":" \".\"}"}}`,
+				"content_block_stop", `{"type":"content_block_stop","index":1}`,
+				"message_delta", `{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+				"message_stop", `{"type":"message_stop"}`,
+			))
+		},
+	))
+	defer server.Close()
+
+	provider := NewAnthropicProvider("token", anthropic.WithBaseURL(server.URL))
+
+	output := bytes.NewBuffer(nil)
+
+	completion, err := provider.Complete(context.Background(), CompletionRequest{
+		Model:     "model",
+		Messages:  []Message{NewTextMessage(RoleUser, "hi")},
+		MaxTokens: 100,
+		Stream:    NewTerminalStream(output),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !completion.Streamed {
+		t.Errorf("expected completion to be streamed")
+	}
+
+	if len(completion.Content) != 2 || completion.Content[0].Text != "Let me look." {
+		t.Fatalf("unexpected content: %#v", completion.Content)
+	}
+
+	toolUses := completion.ToolUses()
+	if len(toolUses) != 1 || toolUses[0].ID != "toolu_1" || string(toolUses[0].Input) != `{"path": "."}` {
+		t.Errorf("unexpected tool uses: %#v", toolUses)
+	}
+
+	if completion.StopReason != "tool_use" || completion.Usage.OutputTokens != 20 {
+		t.Errorf("unexpected stop reason or usage: %#v", completion)
+	}
+
+	expected := "{assistant} Let me look.\n{assistant} fs_list: {\"path\": \".\"}\n"
+	if output.String() != expected {
+		t.Errorf("unexpected output:\n%q\n%q", output.String(), expected)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+	defaultOpenAIModel   = "gpt-4o-mini"
+)
+
+// OpenAIProvider talks to any OpenAI-compatible /v1/chat/completions
+// endpoint: OpenAI itself, llama.cpp server, vLLM and so on.
+type OpenAIProvider struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func NewOpenAIProvider(baseURL string, token string) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+
+	return &OpenAIProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  http.DefaultClient,
+	}
+}
+
+type openaiMessage struct {
+	Role       string           `json:"role"`
+	Content    *string          `json:"content"`
+	ToolCalls  []openaiToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openaiToolCall struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function openaiFunctionCall `json:"function"`
+}
+
+type openaiFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type openaiTool struct {
+	Type     string         `json:"type"`
+	Function openaiFunction `json:"function"`
+}
+
+type openaiFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters"`
+}
+
+type openaiRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openaiMessage `json:"messages"`
+	Tools       []openaiTool    `json:"tools,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	Temperature *float64        `json:"temperature,omitempty"`
+}
+
+type openaiResponse struct {
+	Choices []struct {
+		Message      openaiMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (provider *OpenAIProvider) Complete(
+	ctx context.Context,
+	request CompletionRequest,
+) (*Completion, error) {
+	messages, err := toOpenAIMessages(request.System, request.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := openaiRequest{
+		Model:       request.Model,
+		Messages:    messages,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+	}
+
+	for _, tool := range request.Tools {
+		payload.Tools = append(payload.Tools, openaiTool{
+			Type: "function",
+			Function: openaiFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, karma.Format(err, "marshal request")
+	}
+
+	httpRequest, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		provider.baseURL+"/chat/completions",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, karma.Format(err, "create request")
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if provider.token != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+provider.token)
+	}
+
+	httpResponse, err := provider.client.Do(httpRequest)
+	if err != nil {
+		return nil, karma.Format(err, "send request")
+	}
+
+	defer httpResponse.Body.Close()
+
+	raw, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, karma.Format(err, "read response")
+	}
+
+	var response openaiResponse
+	err = json.Unmarshal(raw, &response)
+	if err != nil {
+		// e.g. an error page of a proxy
+		if httpResponse.StatusCode != http.StatusOK {
+			return nil, &StatusError{StatusCode: httpResponse.StatusCode, Message: string(raw)}
+		}
+
+		return nil, karma.Format(
+			err,
+			"unmarshal response (status %d): %s",
+			httpResponse.StatusCode,
+			raw,
+		)
+	}
+
+	if response.Error != nil {
+		return nil, &StatusError{
+			StatusCode: httpResponse.StatusCode,
+			Message:    response.Error.Message,
+		}
+	}
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: httpResponse.StatusCode, Message: string(raw)}
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("response has no choices: %s", raw)
+	}
+
+	choice := response.Choices[0]
+
+	completion := &Completion{
+		StopReason: choice.FinishReason,
+		Usage: Usage{
+			InputTokens:  response.Usage.PromptTokens,
+			OutputTokens: response.Usage.CompletionTokens,
+		},
+	}
+
+	if choice.Message.Content != nil && *choice.Message.Content != "" {
+		completion.Content = append(
+			completion.Content,
+			NewTextContent(*choice.Message.Content),
+		)
+	}
+
+	for i, call := range choice.Message.ToolCalls {
+		id := call.ID
+		if id == "" {
+			// llama.cpp may omit identifiers, but the thread needs them to
+			// bind tool results to calls.
+			id = fmt.Sprintf("call_%d_%d", len(request.Messages), i)
+		}
+
+		arguments := call.Function.Arguments
+		if strings.TrimSpace(arguments) == "" {
+			arguments = "{}"
+		}
+
+		completion.Content = append(
+			completion.Content,
+			NewToolUseContent(id, call.Function.Name, json.RawMessage(arguments)),
+		)
+	}
+
+	return completion, nil
+}
+
+func toOpenAIMessages(system string, thread []Message) ([]openaiMessage, error) {
+	messages := []openaiMessage{}
+
+	if system != "" {
+		messages = append(messages, openaiMessage{
+			Role:    "system",
+			Content: &system,
+		})
+	}
+
+	for index, message := range thread {
+		texts := []string{}
+		calls := []openaiToolCall{}
+		results := []openaiMessage{}
+
+		for _, block := range message.Content {
+			err := block.validate()
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %w", index, err)
+			}
+
+			switch block.Type {
+			case ContentTypeText:
+				texts = append(texts, block.Text)
+			case ContentTypeToolUse:
+				calls = append(calls, openaiToolCall{
+					ID:   block.ToolUse.ID,
+					Type: "function",
+					Function: openaiFunctionCall{
+						Name:      block.ToolUse.Name,
+						Arguments: string(block.ToolUse.Input),
+					},
+				})
+			case ContentTypeToolResult:
+				content := block.ToolResult.Content
+				results = append(results, openaiMessage{
+					Role:       "tool",
+					Content:    &content,
+					ToolCallID: block.ToolResult.ToolUseID,
+				})
+			}
+		}
+
+		// tool results must immediately follow the assistant message with
+		// the tool calls
+		messages = append(messages, results...)
+
+		if len(texts) == 0 && len(calls) == 0 {
+			continue
+		}
+
+		item := openaiMessage{
+			Role:      message.Role,
+			ToolCalls: calls,
+		}
+
+		if len(texts) > 0 {
+			text := strings.Join(texts, "\n")
+			item.Content = &text
+		}
+
+		messages = append(messages, item)
+	}
+
+	return messages, nil
+}
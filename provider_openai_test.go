@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+)
+
+// openAIRequests are the requests recorded in testdata/cassettes/openai
+// from an OpenAI-compatible server.
+var openAIRequests = map[string]CompletionRequest{
+	"text": {
+		Model:     "gpt-4o-mini",
+		System:    "Be brief.",
+		Messages:  []Message{NewTextMessage(RoleUser, "hi")},
+		MaxTokens: 100,
+	},
+	"tool call": {
+		Model: "gpt-4o-mini",
+		Messages: []Message{
+			NewTextMessage(RoleUser, "list files and read notes.txt"),
+			{
+				Role: RoleAssistant,
+				Content: []Content{
+					NewTextContent("Listing."),
+					NewToolUseContent("call_1", "fs_list", json.RawMessage(`{"path":"."}`)),
+				},
+			},
+			{
+				Role: RoleUser,
+				Content: []Content{
+					NewToolResultContent("call_1", `[{"name":"notes.txt"}]`, false),
+				},
+			},
+		},
+		Tools: []ToolDefinition{
+			{
+				Name:        "fs_list",
+				Description: "List files",
+				InputSchema: &jsonschema.Schema{Type: "object"},
+			},
+			{
+				Name:        "fs_read",
+				Description: "Read file",
+				InputSchema: &jsonschema.Schema{Type: "object"},
+			},
+		},
+		MaxTokens: 100,
+	},
+	"error": {
+		Model:     "missing-model",
+		Messages:  []Message{NewTextMessage(RoleUser, "hi")},
+		MaxTokens: 100,
+	},
+}
+
+func TestOpenAIProvider_Cassette(t *testing.T) {
+	cassette, err := NewCassette(filepath.Join("testdata", "cassettes", "openai"), CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewOpenAIProvider("http://localhost:8080/v1", "token")
+	provider.client = cassette.Client()
+
+	complete := func(name string) (*Completion, error) {
+		return provider.Complete(context.Background(), openAIRequests[name])
+	}
+
+	completion, err := complete("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(completion.Content) != 1 || completion.Content[0].Text != "Hello! How can I help?" ||
+		completion.StopReason != "stop" || completion.Usage.InputTokens != 12 ||
+		completion.Usage.OutputTokens != 7 {
+		t.Errorf("unexpected text completion: %#v", completion)
+	}
+
+	completion, err = complete("tool call")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toolUses := completion.ToolUses()
+	if len(toolUses) != 1 || toolUses[0].ID != "call_2" || toolUses[0].Name != "fs_read" ||
+		string(toolUses[0].Input) != `{"path":"notes.txt"}` || completion.StopReason != "tool_calls" {
+		t.Errorf("unexpected tool call completion: %#v", completion)
+	}
+
+	_, err = complete("error")
+	if err == nil || !strings.Contains(err.Error(), "status 404") ||
+		!strings.Contains(err.Error(), "model `missing-model` does not exist") {
+		t.Errorf("expected api error, got %v", err)
+	}
+}
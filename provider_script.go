@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/reconquest/karma-go"
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptProvider replays canned assistant turns from a YAML or JSON file,
+// it is used for tests and for reproducing sessions without network.
+//
+//	turns:
+//	  - text: Let me look around.
+//	    tool_uses:
+//	      - name: fs_list
+//	        input: {path: .}
+//	  - text: Done.
+type ScriptProvider struct {
+	turns []ScriptTurn
+	index int
+	mutex sync.Mutex
+}
+
+type Script struct {
+	Turns []ScriptTurn `yaml:"turns"`
+}
+
+type ScriptTurn struct {
+	Text     string          `yaml:"text"`
+	ToolUses []ScriptToolUse `yaml:"tool_uses"`
+}
+
+type ScriptToolUse struct {
+	ID    string         `yaml:"id"`
+	Name  string         `yaml:"name"`
+	Input map[string]any `yaml:"input"`
+}
+
+func NewScriptProvider(path string) (*ScriptProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, karma.Format(err, "read script: %s", path)
+	}
+
+	// JSON is a subset of YAML, so both are accepted
+	var script Script
+	err = yaml.Unmarshal(data, &script)
+	if err != nil {
+		return nil, karma.Format(err, "decode script: %s", path)
+	}
+
+	return &ScriptProvider{turns: script.Turns}, nil
+}
+
+func (provider *ScriptProvider) Complete(
+	ctx context.Context,
+	request CompletionRequest,
+) (*Completion, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.index >= len(provider.turns) {
+		return nil, ErrNoMoreCompletions
+	}
+
+	turn := provider.turns[provider.index]
+	provider.index++
+
+	completion := &Completion{StopReason: "end_turn"}
+
+	if turn.Text != "" {
+		completion.Content = append(completion.Content, NewTextContent(turn.Text))
+
+		if request.Stream != nil {
+			request.Stream.Text(turn.Text)
+			request.Stream.Stop()
+		}
+	}
+
+	for i, toolUse := range turn.ToolUses {
+		id := toolUse.ID
+		if id == "" {
+			id = fmt.Sprintf("script_%d_%d", provider.index, i)
+		}
+
+		input := toolUse.Input
+		if input == nil {
+			input = map[string]any{}
+		}
+
+		raw, err := json.Marshal(input)
+		if err != nil {
+			return nil, karma.Format(err, "marshal input of %s", toolUse.Name)
+		}
+
+		completion.Content = append(
+			completion.Content,
+			NewToolUseContent(id, toolUse.Name, raw),
+		)
+
+		if request.Stream != nil {
+			request.Stream.ToolUse(id, toolUse.Name)
+			request.Stream.ToolInput(string(raw))
+			request.Stream.Stop()
+		}
+
+		completion.StopReason = "tool_use"
+	}
+
+	completion.Streamed = request.Stream != nil
+
+	return completion, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptProvider_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.json")
+
+	err := os.WriteFile(path, []byte(`{
+		"turns": [
+			{"text": "hi", "tool_uses": [{"name": "fs_list", "input": {"path": "."}}]},
+			{"text": "bye"}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := NewScriptProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completion, err := provider.Complete(context.Background(), CompletionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toolUses := completion.ToolUses()
+	if len(completion.Content) != 2 || len(toolUses) != 1 {
+		t.Fatalf("unexpected completion: %#v", completion)
+	}
+
+	if toolUses[0].Name != "fs_list" || string(toolUses[0].Input) != `{"path":"."}` {
+		t.Errorf("unexpected tool use: %#v", toolUses[0])
+	}
+
+	completion, err = provider.Complete(context.Background(), CompletionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if completion.Content[0].Text != "bye" || len(completion.ToolUses()) != 0 {
+		t.Errorf("unexpected completion: %#v", completion)
+	}
+
+	_, err = provider.Complete(context.Background(), CompletionRequest{})
+	if !errors.Is(err, ErrNoMoreCompletions) {
+		t.Errorf("expected ErrNoMoreCompletions, got %v", err)
+	}
+}
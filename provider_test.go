@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liushuangls/go-anthropic/v2"
+	"go.uber.org/ratelimit"
+)
+
+func TestContent_UnmarshalLegacy(t *testing.T) {
+	data := `[
+		{"role": "user", "content": [{"type": "text", "text": "list files"}]},
+		{"role": "assistant", "content": [
+			{"type": "tool_use", "id": "toolu_1", "name": "fs_list", "input": {"path": "."}}
+		]},
+		{"role": "user", "content": [
+			{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "a.txt"}], "is_error": true}
+		]}
+	]`
+
+	var thread []Message
+	err := json.Unmarshal([]byte(data), &thread)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toolUse := thread[1].Content[0].ToolUse
+	if toolUse == nil || toolUse.ID != "toolu_1" || toolUse.Name != "fs_list" ||
+		string(toolUse.Input) != `{"path": "."}` {
+		t.Fatalf("unexpected tool use: %#v", toolUse)
+	}
+
+	toolResult := thread[2].Content[0].ToolResult
+	if toolResult == nil || toolResult.ToolUseID != "toolu_1" ||
+		toolResult.Content != "a.txt" || !toolResult.IsError {
+		t.Fatalf("unexpected tool result: %#v", toolResult)
+	}
+
+	_, err = toAnthropicMessages(thread)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the current layout survives a round trip
+	encoded, err := json.Marshal(thread)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []Message
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded[2].Content[0].ToolResult.Content != "a.txt" {
+		t.Errorf("unexpected round trip: %s", encoded)
+	}
+}
+
+func TestToMessages_MissingPayload(t *testing.T) {
+	thread := []Message{
+		{Role: RoleAssistant, Content: []Content{{Type: ContentTypeToolUse}}},
+	}
+
+	_, err := toAnthropicMessages(thread)
+	if err == nil || !strings.Contains(err.Error(), "no tool call") {
+		t.Errorf("expected missing payload error, got: %v", err)
+	}
+
+	thread = []Message{
+		{Role: RoleUser, Content: []Content{{Type: ContentTypeToolResult}}},
+	}
+
+	_, err = toOpenAIMessages("", thread)
+	if err == nil || !strings.Contains(err.Error(), "no tool result") {
+		t.Errorf("expected missing payload error, got: %v", err)
+	}
+}
+
+// failingProvider fails with the errors before it completes the thread,
+// streamed attempts print the partial text first.
+type failingProvider struct {
+	errors   []error
+	requests []CompletionRequest
+}
+
+func (provider *failingProvider) Complete(
+	ctx context.Context,
+	request CompletionRequest,
+) (*Completion, error) {
+	provider.requests = append(provider.requests, request)
+
+	if len(provider.errors) > 0 {
+		err := provider.errors[0]
+		provider.errors = provider.errors[1:]
+
+		if request.Stream != nil {
+			request.Stream.Text("partial")
+		}
+
+		return nil, err
+	}
+
+	if request.Stream != nil {
+		request.Stream.Text("done")
+	}
+
+	return &Completion{
+		Content:  []Content{NewTextContent("done")},
+		Streamed: request.Stream != nil,
+	}, nil
+}
+
+func TestDispatcher_CompleteRetry(t *testing.T) {
+	defer func(delay time.Duration, limit ratelimit.Limiter) {
+		retryDelay = delay
+		requestRateLimit = limit
+	}(retryDelay, requestRateLimit)
+
+	retryDelay = time.Millisecond
+	requestRateLimit = ratelimit.NewUnlimited()
+
+	transport := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	unauthorized := &StatusError{StatusCode: 401, Message: "invalid token"}
+	limited := &StatusError{StatusCode: 429, Message: "rate limited"}
+
+	tests := []struct {
+		name     string
+		errors   []error
+		requests int
+		failed   bool
+	}{
+		{"transport", []error{fmt.Errorf("send request: %w", transport)}, 2, false},
+		{"server", []error{&StatusError{StatusCode: 503, Message: "unavailable"}}, 2, false},
+		{"overloaded", []error{&anthropic.APIError{Type: anthropic.ErrTypeOverloaded}}, 2, false},
+		{"unauthorized", []error{unauthorized}, 1, true},
+		{"invalid request", []error{&anthropic.APIError{Type: anthropic.ErrTypeInvalidRequest}}, 1, true},
+		{"not found", []error{&anthropic.RequestError{StatusCode: 404}}, 1, true},
+		{"no more", []error{ErrNoMoreCompletions}, 1, true},
+		{"limited", []error{limited, limited, limited, limited, limited, limited}, maxRetries + 1, true},
+	}
+	for _, test := range tests {
+		provider := &failingProvider{errors: test.errors}
+
+		dispatcher := NewDispatcher(t.TempDir(), "model", false, provider)
+
+		_, err := dispatcher.complete()
+		if (err != nil) != test.failed || len(provider.requests) != test.requests {
+			t.Errorf("%s: expected %d requests and failure %v, got %d: %v",
+				test.name, test.requests, test.failed, len(provider.requests), err)
+		}
+	}
+}
+
+func TestDispatcher_CompleteRetryStream(t *testing.T) {
+	defer func(delay time.Duration, limit ratelimit.Limiter) {
+		retryDelay = delay
+		requestRateLimit = limit
+	}(retryDelay, requestRateLimit)
+
+	retryDelay = time.Millisecond
+	requestRateLimit = ratelimit.NewUnlimited()
+
+	provider := &failingProvider{errors: []error{&StatusError{StatusCode: 500}}}
+
+	output := bytes.NewBuffer(nil)
+
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, provider)
+	dispatcher.stream = NewTerminalStream(output)
+
+	completion, err := dispatcher.complete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the retried completion is logged as a message instead of streamed
+	if completion.Streamed || provider.requests[1].Stream != nil {
+		t.Errorf("expected the retry not to be streamed")
+	}
+
+	if strings.Count(output.String(), "partial") != 1 || strings.Contains(output.String(), "done") {
+		t.Errorf("unexpected streamed output: %q", output.String())
+	}
+}
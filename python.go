@@ -0,0 +1,66 @@
+package main
+
+// PythonOptions configure the python_execute tool, it's available only on
+// Linux where scripts run in namespaces that see only the workspace and
+// have no network.
+//
+//	python:
+//	  interpreter: python3
+//	  timeout: 60
+//	  cpu: 30
+//	  memory: 512
+type PythonOptions struct {
+	Interpreter string `yaml:"interpreter"`
+
+	// Timeout is the wall time limit of a script in seconds
+	Timeout int `yaml:"timeout"`
+
+	// CPU is the CPU time limit of a script in seconds
+	CPU int `yaml:"cpu"`
+
+	// Memory is the address space limit of a script in megabytes
+	Memory int `yaml:"memory"`
+
+	MaxOutput int `yaml:"max_output"`
+}
+
+var defaultPythonOptions = PythonOptions{
+	Interpreter: "python3",
+	Timeout:     60,
+	CPU:         30,
+	Memory:      1024,
+	MaxOutput:   16 << 10,
+}
+
+func (options PythonOptions) merge(other PythonOptions) PythonOptions {
+	if other.Interpreter != "" {
+		options.Interpreter = other.Interpreter
+	}
+
+	if other.Timeout != 0 {
+		options.Timeout = other.Timeout
+	}
+
+	if other.CPU != 0 {
+		options.CPU = other.CPU
+	}
+
+	if other.Memory != 0 {
+		options.Memory = other.Memory
+	}
+
+	if other.MaxOutput != 0 {
+		options.MaxOutput = other.MaxOutput
+	}
+
+	return options
+}
+
+type PythonArguments struct {
+	ScriptName string `json:"script_name"`
+	Code       string `json:"code"`
+}
+
+func (arguments PythonArguments) String() string {
+	return arguments.ScriptName + "\n" + arguments.Code
+}
@@ -0,0 +1,481 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxInitEnv passes the spec to the copy of aight that is started in
+// new namespaces to prepare the sandbox and exec the interpreter.
+const sandboxInitEnv = "AIGHT_SANDBOX_INIT"
+
+const (
+	secbitNoRoot              = 1 << 0
+	secbitNoRootLocked        = 1 << 1
+	secbitNoSetuidFixup       = 1 << 2
+	secbitNoSetuidFixupLocked = 1 << 3
+)
+
+var (
+	// systemDirs are mounted read-only into the sandbox for the interpreter
+	// and its libraries
+	systemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32"}
+
+	devices = []string{"null", "zero", "full", "random", "urandom"}
+
+	reScriptName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+type sandboxSpec struct {
+	// Root is an empty directory the root of the sandbox is built in
+	Root string `json:"root"`
+
+	Workspace   string   `json:"workspace"`
+	Scripts     string   `json:"scripts"`
+	Script      string   `json:"script"`
+	Interpreter string   `json:"interpreter"`
+	Binds       []string `json:"binds"`
+
+	CPU    int `json:"cpu"`
+	Memory int `json:"memory"`
+}
+
+func init() {
+	value := os.Getenv(sandboxInitEnv)
+	if value == "" {
+		return
+	}
+
+	var spec sandboxSpec
+	err := json.Unmarshal([]byte(value), &spec)
+	if err == nil {
+		err = sandboxInit(spec)
+	}
+
+	fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+	os.Exit(126)
+}
+
+func (dispatcher *Dispatcher) registerPython() {
+	registerMutating(
+		dispatcher,
+		"python_execute", "Execute python code. This is especially useful for math. "+
+			"The script runs in the workspace directory without network access.",
+		guardError(dispatcher.python),
+	)
+}
+
+func (dispatcher *Dispatcher) python(args PythonArguments) (any, error) {
+	if dispatcher.overlay != nil {
+		return nil, errors.New("scripts can't be simulated in dry-run mode")
+	}
+
+	options := dispatcher.pythonOptions
+
+	interpreter, binds, err := findPython(options.Interpreter)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "aight-python-")
+	if err != nil {
+		return nil, karma.Format(err, "create temporary directory")
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := reScriptName.ReplaceAllString(filepath.Base(args.ScriptName), "_")
+	if !strings.HasSuffix(name, ".py") {
+		name += ".py"
+	}
+
+	spec := sandboxSpec{
+		Root:        filepath.Join(dir, "root"),
+		Workspace:   dispatcher.cwd,
+		Scripts:     filepath.Join(dir, "scripts"),
+		Script:      name,
+		Interpreter: interpreter,
+		Binds:       binds,
+		CPU:         options.CPU,
+		Memory:      options.Memory,
+	}
+
+	for _, path := range []string{spec.Root, spec.Scripts} {
+		err = os.Mkdir(path, 0755)
+		if err != nil {
+			return nil, karma.Format(err, "create directory: %s", path)
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(spec.Scripts, name), []byte(args.Code), 0644)
+	if err != nil {
+		return nil, karma.Format(err, "write python code")
+	}
+
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Duration(options.Timeout)*time.Second,
+	)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Env = []string{sandboxInitEnv + "=" + string(encoded)}
+	cmd.WaitDelay = shellWaitDelay
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	output := bytes.NewBuffer(nil)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	result := CommandResult{}
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		result.TimedOut = true
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil && !errors.Is(err, exec.ErrWaitDelay):
+		return nil, karma.Format(
+			err,
+			"start sandbox, unprivileged user namespaces may be disabled",
+		)
+	}
+
+	result.Output, result.Truncated = truncateMiddle(output.String(), options.MaxOutput)
+
+	if result.TimedOut {
+		result.Output += fmt.Sprintf("\n[killed after %d seconds]", options.Timeout)
+	}
+
+	return result, nil
+}
+
+// findPython returns the real path of the interpreter and its prefixes that
+// are mounted into the sandbox, e.g. of a virtualenv or pyenv.
+func findPython(interpreter string) (string, []string, error) {
+	path, err := exec.LookPath(interpreter)
+	if err != nil {
+		return "", nil, karma.Format(err, "find python interpreter")
+	}
+
+	output, err := exec.Command(
+		path, "-c",
+		"import sys; print(sys.executable); print(sys.prefix); print(sys.base_prefix)",
+	).Output()
+	if err != nil {
+		return "", nil, karma.Format(err, "get python prefix: %s", path)
+	}
+
+	lines := strings.Fields(string(output))
+	if len(lines) != 3 {
+		return "", nil, fmt.Errorf("unexpected output of %s: %q", path, output)
+	}
+
+	executable, err := filepath.EvalSymlinks(lines[0])
+	if err != nil {
+		return "", nil, karma.Format(err, "resolve python executable")
+	}
+
+	binds := []string{}
+	for _, prefix := range lines[1:] {
+		resolved, err := filepath.EvalSymlinks(prefix)
+		if err != nil {
+			return "", nil, karma.Format(err, "resolve python prefix")
+		}
+
+		binds = append(binds, prefix, resolved)
+	}
+
+	return executable, binds, nil
+}
+
+// sandboxInit runs as the first process of new user, mount, network and
+// pid namespaces. It builds the root that contains only system
+// directories, the interpreter and the workspace, pivots into it, sets
+// limits, drops privileges and execs the interpreter.
+func sandboxInit(spec sandboxSpec) error {
+	// securebits and no_new_privs are per thread, exec must be done by the
+	// thread that set them
+	runtime.LockOSThread()
+
+	err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")
+	if err != nil {
+		return karma.Format(err, "make mounts private")
+	}
+
+	root := spec.Root
+
+	err = unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755,size=1m")
+	if err != nil {
+		return karma.Format(err, "mount root")
+	}
+
+	binds := map[string]bool{}
+	for _, path := range append(systemDirs, spec.Binds...) {
+		if binds[path] {
+			continue
+		}
+
+		binds[path] = true
+
+		err := bindPath(path, filepath.Join(root, path), true)
+		if err != nil {
+			return karma.Format(err, "mount %s", path)
+		}
+	}
+
+	err = bindPath(spec.Workspace, filepath.Join(root, "workspace"), false)
+	if err != nil {
+		return karma.Format(err, "mount workspace")
+	}
+
+	// the config of the repository runs commands outside of the sandbox,
+	// e.g. filter drivers on git_status, and the state directory keeps
+	// checkpoints that undo restores
+	for _, name := range []string{".git", stateDir} {
+		err := protectPath(spec.Workspace, filepath.Join(root, "workspace"), name)
+		if err != nil {
+			return karma.Format(err, "mount %s read-only", name)
+		}
+	}
+
+	err = bindPath(spec.Scripts, filepath.Join(root, "scripts"), true)
+	if err != nil {
+		return karma.Format(err, "mount scripts")
+	}
+
+	for _, name := range []string{"tmp", "proc", "dev"} {
+		err := os.Mkdir(filepath.Join(root, name), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = unix.Mount("tmpfs", filepath.Join(root, "tmp"), "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+	if err != nil {
+		return karma.Format(err, "mount /tmp")
+	}
+
+	err = unix.Mount("proc", filepath.Join(root, "proc"), "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+	if err != nil {
+		return karma.Format(err, "mount /proc")
+	}
+
+	for _, name := range devices {
+		err := bindPath(filepath.Join("/dev", name), filepath.Join(root, "dev", name), false)
+		if err != nil {
+			return karma.Format(err, "mount /dev/%s", name)
+		}
+	}
+
+	err = pivotRoot(root)
+	if err != nil {
+		return err
+	}
+
+	err = os.Chdir("/workspace")
+	if err != nil {
+		return err
+	}
+
+	limits := map[int]uint64{
+		unix.RLIMIT_CPU: uint64(spec.CPU),
+		unix.RLIMIT_AS:  uint64(spec.Memory) << 20,
+	}
+	for resource, limit := range limits {
+		if limit == 0 {
+			continue
+		}
+
+		err := unix.Setrlimit(resource, &unix.Rlimit{Cur: limit, Max: limit})
+		if err != nil {
+			return karma.Format(err, "set limit %d", resource)
+		}
+	}
+
+	// uid 0 of the namespace must not get capabilities back on exec
+	err = unix.Prctl(
+		unix.PR_SET_SECUREBITS,
+		secbitNoRoot|secbitNoRootLocked|secbitNoSetuidFixup|secbitNoSetuidFixupLocked,
+		0, 0, 0,
+	)
+	if err != nil {
+		return karma.Format(err, "set securebits")
+	}
+
+	err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	if err != nil {
+		return karma.Format(err, "set no_new_privs")
+	}
+
+	return unix.Exec(
+		spec.Interpreter,
+		[]string{spec.Interpreter, filepath.Join("/scripts", spec.Script)},
+		[]string{
+			"PATH=/usr/local/bin:/usr/bin:/bin",
+			"HOME=/tmp",
+			"LANG=C.UTF-8",
+			"PYTHONDONTWRITEBYTECODE=1",
+		},
+	)
+}
+
+// bindPath mounts the path to the target, symlinks are copied as they are,
+// missing paths are skipped.
+func bindPath(path string, target string, readonly bool) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(link, target)
+
+	case info.IsDir():
+		err = os.MkdirAll(target, 0755)
+
+	default:
+		err = os.WriteFile(target, nil, 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = unix.Mount(path, target, "", unix.MS_BIND|unix.MS_REC, "")
+	if err != nil || !readonly {
+		return err
+	}
+
+	return remountReadonly(target)
+}
+
+// protectPath mounts the file of the workspace read-only over the mounted
+// workspace. The file is resolved first, since the file itself is not
+// replaced the way bindPath replaces files and symlinks of the targets.
+func protectPath(workspace string, target string, name string) error {
+	root, err := filepath.EvalSymlinks(workspace)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// files outside of the workspace are not mounted at all
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || !isLocal(relative) {
+		return nil
+	}
+
+	target = filepath.Join(target, relative)
+
+	err = unix.Mount(resolved, target, "", unix.MS_BIND|unix.MS_REC, "")
+	if err != nil {
+		return err
+	}
+
+	return remountReadonly(target)
+}
+
+func remountReadonly(target string) error {
+	// flags of the original mount are locked in the user namespace and
+	// have to be kept on remount
+	var stat unix.Statfs_t
+	err := unix.Statfs(target, &stat)
+	if err != nil {
+		return err
+	}
+
+	locked := uintptr(stat.Flags) & (unix.ST_NOSUID | unix.ST_NODEV | unix.ST_NOEXEC |
+		unix.ST_NOATIME | unix.ST_NODIRATIME | unix.ST_RELATIME)
+
+	return unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|locked, "")
+}
+
+func pivotRoot(root string) error {
+	old := filepath.Join(root, ".old")
+
+	err := os.Mkdir(old, 0700)
+	if err != nil {
+		return err
+	}
+
+	err = unix.PivotRoot(root, old)
+	if err != nil {
+		return karma.Format(err, "pivot root")
+	}
+
+	err = os.Chdir("/")
+	if err != nil {
+		return err
+	}
+
+	err = unix.Unmount("/.old", unix.MNT_DETACH)
+	if err != nil {
+		return karma.Format(err, "unmount old root")
+	}
+
+	err = os.Remove("/.old")
+	if err != nil {
+		return err
+	}
+
+	return unix.Mount("", "/", "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, "")
+}
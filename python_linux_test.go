@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runPython(t *testing.T, dispatcher *Dispatcher, code string) CommandResult {
+	t.Helper()
+
+	value, err := dispatcher.python(PythonArguments{ScriptName: "test", Code: code})
+	if err != nil {
+		t.Skipf("python sandbox is not available: %s", err)
+	}
+
+	return value.(CommandResult)
+}
+
+func TestDispatcher_Python(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	// the directory of the test is not in the workspace
+	outside, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dispatcher.cwd, "input.txt"), []byte("21"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runPython(t, dispatcher, strings.Join([]string{
+		"import os, socket",
+		"print(int(open('input.txt').read()) * 2)",
+		"open('output.txt', 'w').write('written')",
+		"print(os.path.exists('/etc/passwd'), os.path.exists('" + outside + "'))",
+		"try:",
+		"    socket.create_connection(('1.1.1.1', 53), timeout=1)",
+		"    print('online')",
+		"except OSError:",
+		"    print('offline')",
+	}, "\n"))
+
+	if result.ExitCode != 0 || result.Output != "42\nFalse False\noffline\n" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dispatcher.cwd, "output.txt"))
+	if err != nil || string(contents) != "written" {
+		t.Errorf("expected output.txt in the workspace, got %q %v", contents, err)
+	}
+
+	entries, _ := os.ReadDir(dispatcher.cwd)
+	if len(entries) != 2 {
+		t.Errorf("expected no scripts left in the workspace, got %v", entries)
+	}
+
+	result = runPython(t, dispatcher, "open('/usr/aight', 'w')")
+	if result.ExitCode == 0 || !strings.Contains(result.Output, "Read-only file system") {
+		t.Errorf("expected system directories to be read-only, got %#v", result)
+	}
+}
+
+func TestDispatcher_PythonLimits(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+	dispatcher.pythonOptions.Timeout = 1
+	dispatcher.pythonOptions.Memory = 256
+
+	result := runPython(t, dispatcher, "import time\ntime.sleep(30)")
+	if !result.TimedOut {
+		t.Errorf("expected the script to time out, got %#v", result)
+	}
+
+	dispatcher.pythonOptions.Timeout = 30
+
+	result = runPython(t, dispatcher, "x = bytearray(512 << 20)")
+	if result.ExitCode == 0 || !strings.Contains(result.Output, "MemoryError") {
+		t.Errorf("expected the memory limit to be hit, got %#v", result)
+	}
+}
+
+func TestDispatcher_PythonProtectedDirs(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	writeFiles(t, dispatcher.cwd, map[string]string{
+		".git/config":                "[core]\n",
+		filepath.Join(stateDir, "x"): "state\n",
+	})
+
+	result := runPython(t, dispatcher, strings.Join([]string{
+		"for path in ['.git/config', '.git/new', '.aight/x', '.aight/config.yaml']:",
+		"    try:",
+		"        open(path, 'a').write('[filter \"x\"]\\n\\tclean = touch pwned\\n')",
+		"        print(path, 'written')",
+		"    except OSError as err:",
+		"        print(path, err.strerror)",
+		"open('.gitattributes', 'w').write('* filter=x\\n')",
+	}, "\n"))
+
+	expected := strings.Join([]string{
+		".git/config Read-only file system",
+		".git/new Read-only file system",
+		".aight/x Read-only file system",
+		".aight/config.yaml Read-only file system",
+		"",
+	}, "\n")
+	if result.ExitCode != 0 || result.Output != expected {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dispatcher.cwd, ".git", "config"))
+	if err != nil || string(contents) != "[core]\n" {
+		t.Errorf("expected .git/config to be unchanged, got %q %v", contents, err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// registerPython does nothing, scripts can be isolated only on Linux.
+func (dispatcher *Dispatcher) registerPython() {}
@@ -3,5 +3,5 @@ package main
 import "go.uber.org/ratelimit"
 
 var (
-	requestRateLimit = ratelimit.New(5)
+	requestRateLimit = ratelimit.New(defaultRateLimit)
 )
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/reconquest/karma-go"
+)
+
+// maxSymlinks limits symlinks followed while resolving a path, like ELOOP.
+const maxSymlinks = 40
+
+// sandbox returns the absolute path of the workspace file. The path is
+// cleaned and its symlinks are resolved to make sure that neither the path
+// nor the file it points to are outside of the workspace.
+func (dispatcher *Dispatcher) sandbox(path string) (string, error) {
+	full, _, err := dispatcher.resolveSandbox(path)
+
+	return full, err
+}
+
+// sandboxWrite is sandbox for paths that are changed. The .git directory
+// is never written, since its config and hooks run commands from tools
+// that don't need approval, e.g. git_status, and neither is the state
+// directory, since it keeps the config, threads and checkpoints that undo
+// restores files from.
+func (dispatcher *Dispatcher) sandboxWrite(path string) (string, error) {
+	full, resolved, err := dispatcher.resolveSandbox(path)
+	if err != nil {
+		return full, err
+	}
+
+	name, err := filepath.Rel(dispatcher.cwd, full)
+	if err != nil {
+		return full, err
+	}
+
+	for _, dir := range []string{".git", stateDir} {
+		if isInDir(name, dir) || isInDir(resolved, dir) {
+			return full, fmt.Errorf("path must not be in the %s directory: %s", dir, path)
+		}
+	}
+
+	return full, nil
+}
+
+// isInDir reports whether the relative path is in the top-level directory
+// of the workspace, case-insensitive file systems are taken into account.
+func isInDir(name string, dir string) bool {
+	first, _, _ := strings.Cut(filepath.ToSlash(name), "/")
+
+	return strings.EqualFold(first, dir)
+}
+
+// resolveSandbox returns the absolute path of the workspace file and the
+// path with resolved symlinks relative to the workspace.
+func (dispatcher *Dispatcher) resolveSandbox(path string) (string, string, error) {
+	if path == "/" {
+		path = "."
+	}
+
+	if filepath.IsAbs(path) {
+		return path, "", fmt.Errorf("path must be relative: %s", path)
+	}
+
+	name := filepath.Clean(path)
+	if !isLocal(name) {
+		return path, "", fmt.Errorf("path must not point outside of the workspace: %s", path)
+	}
+
+	root, err := filepath.EvalSymlinks(dispatcher.cwd)
+	if err != nil {
+		return path, "", karma.Format(relativeError(dispatcher.cwd, err), "resolve workspace")
+	}
+
+	resolved, err := resolvePath(root, name)
+	if err != nil {
+		return path, "", karma.Format(relativeError(root, err), "resolve path: %s", path)
+	}
+
+	// the resolved path is not reported, it is a path of the host
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || !isLocal(relative) {
+		return path, "", fmt.Errorf(
+			"path must not point outside of the workspace: %s is a symlink to outside",
+			path,
+		)
+	}
+
+	return filepath.Join(dispatcher.cwd, name), relative, nil
+}
+
+// open opens the workspace file, on Linux the path is resolved by the kernel
+// beneath the workspace so a symlink swapped in after the check can't be
+// followed outside of it. Files opened for writing are checked by
+// sandboxWrite.
+func (dispatcher *Dispatcher) open(
+	path string,
+	flag int,
+	perm os.FileMode,
+) (*os.File, error) {
+	sandbox := dispatcher.sandbox
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		sandbox = dispatcher.sandboxWrite
+	}
+
+	full, err := sandbox(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := filepath.Rel(dispatcher.cwd, full)
+	if err != nil {
+		return nil, err
+	}
+
+	return openBeneath(dispatcher.cwd, name, flag, perm)
+}
+
+// openRelative opens the file of the root as usual, errors are relative to
+// the root.
+func openRelative(root string, name string, flag int, perm os.FileMode) (*os.File, error) {
+	fd, err := os.OpenFile(filepath.Join(root, name), flag, perm)
+	if err != nil {
+		return nil, relativeError(root, err)
+	}
+
+	return fd, nil
+}
+
+// relativeError replaces the path of the file error with the path relative
+// to the root, so errors returned to the model don't expose host paths.
+func relativeError(root string, err error) error {
+	relative := func(path string) string {
+		name, err := filepath.Rel(root, path)
+		if err != nil || !isLocal(name) {
+			return filepath.Base(path)
+		}
+
+		return name
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return &os.PathError{Op: pathErr.Op, Path: relative(pathErr.Path), Err: pathErr.Err}
+	}
+
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		return &os.LinkError{
+			Op:  linkErr.Op,
+			Old: relative(linkErr.Old),
+			New: relative(linkErr.New),
+			Err: linkErr.Err,
+		}
+	}
+
+	return err
+}
+
+// isLocal reports whether the cleaned relative path stays within its root.
+func isLocal(name string) bool {
+	return name != ".." && !strings.HasPrefix(name, ".."+string(filepath.Separator))
+}
+
+// resolvePath resolves symlinks of the relative path like the kernel does.
+// Unlike filepath.EvalSymlinks the path doesn't have to exist, and dangling
+// symlinks are resolved to where a new file would be created.
+func resolvePath(root string, name string) (string, error) {
+	resolved := root
+	parts := strings.Split(name, string(filepath.Separator))
+
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			// nothing beyond a missing element can be a symlink
+			return filepath.Join(append([]string{next}, parts...)...), nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: next, Err: syscall.ELOOP}
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = string(filepath.Separator)
+		}
+
+		parts = append(strings.Split(target, string(filepath.Separator)), parts...)
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openBeneath opens the file using openat2 with RESOLVE_BENEATH, so the
+// kernel refuses to resolve it outside of the root. Kernels older than 5.6
+// don't have openat2, the file is opened as usual then. Errors and the name
+// of the file are relative to the root.
+func openBeneath(root string, name string, flag int, perm os.FileMode) (*os.File, error) {
+	dir, err := os.Open(root)
+	if err != nil {
+		return nil, relativeError(root, err)
+	}
+
+	defer dir.Close()
+
+	how := unix.OpenHow{
+		Flags:   uint64(flag | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+
+	// the kernel rejects mode if the file is not created
+	if flag&os.O_CREATE != 0 {
+		how.Mode = uint64(perm.Perm())
+	}
+
+	fd, err := unix.Openat2(int(dir.Fd()), name, &how)
+	if errors.Is(err, unix.ENOSYS) {
+		return openRelative(root, name, flag, perm)
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "openat2", Path: name, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), name), nil
+}
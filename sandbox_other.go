@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// openBeneath opens the file of the root, the path is expected to be
+// checked by sandbox already.
+func openBeneath(root string, name string, flag int, perm os.FileMode) (*os.File, error) {
+	return openRelative(root, name, flag, perm)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reconquest/karma-go"
+)
+
+func TestDispatcher_Sandbox(t *testing.T) {
+	outside := t.TempDir()
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	symlink := func(target string, name string) {
+		err := os.Symlink(target, filepath.Join(dispatcher.cwd, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.MkdirAll(filepath.Join(dispatcher.cwd, "src"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	symlink(outside, "escape")
+	symlink(filepath.Join(outside, "missing"), "dangling")
+	symlink("src", "sources")
+	symlink("../src", "src/self")
+	symlink("loop", "loop")
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{".", true},
+		{"/", true},
+		{"foo..bar", true},
+		{"src/../main.go", true},
+		{"src/new/file.go", true},
+		{"sources/main.go", true},
+		{"src/self/self/main.go", true},
+		{"/etc/passwd", false},
+		{"..", false},
+		{"../main.go", false},
+		{"src/../../main.go", false},
+		{"escape", false},
+		{"escape/passwd", false},
+		{"dangling", false},
+		{"loop", false},
+	}
+
+	for _, test := range tests {
+		_, err := dispatcher.sandbox(test.path)
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: expected allowed %v, got %v", test.path, test.allowed, err)
+		}
+	}
+}
+
+func TestDispatcher_SandboxTools(t *testing.T) {
+	outside := t.TempDir()
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(outside, filepath.Join(dispatcher.cwd, "escape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dispatcher.handleToolCalls([]ToolUse{
+		{ID: "read", Name: "fs_read", Input: []byte(`{"path":"escape/secret"}`)},
+		{ID: "write", Name: "fs_write", Input: []byte(`{"path":"escape/new","contents":"x"}`)},
+		{ID: "patch", Name: "fs_patch", Input: []byte(
+			`{"patch":"--- a/escape/secret\n+++ b/escape/secret\n@@ -1 +1 @@\n-secret\n+public\n"}`,
+		)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range dispatcher.thread[0].Content {
+		result := content.ToolResult
+		if !strings.Contains(result.Content, "outside of the workspace") {
+			t.Errorf("%s: expected to be rejected, got %s", result.ToolUseID, result.Content)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Errorf("file is written outside of the workspace: %v", err)
+	}
+
+	contents, _ := os.ReadFile(filepath.Join(outside, "secret"))
+	if string(contents) != "secret\n" {
+		t.Errorf("file is patched outside of the workspace: %q", contents)
+	}
+}
+
+func TestOpenBeneath(t *testing.T) {
+	root := t.TempDir()
+
+	err := os.Symlink(t.TempDir(), filepath.Join(root, "escape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := openBeneath(root, "escape/new", os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		fd.Close()
+		t.Skip("openat2 is not supported, the sandbox check is the only guard")
+	}
+
+	fd, err = openBeneath(root, "inside", os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fd.Close()
+}
+
+func TestDispatcher_SandboxErrors(t *testing.T) {
+	outside := t.TempDir()
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	writeFiles(t, dispatcher.cwd, map[string]string{"file.txt": "text\n"})
+
+	for name, target := range map[string]string{
+		"escape": outside,
+		"loop":   "loop",
+	} {
+		err := os.Symlink(target, filepath.Join(dispatcher.cwd, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	calls := map[string]func() (any, error){
+		"sandbox escape": func() (any, error) { return dispatcher.sandbox("escape/passwd") },
+		"sandbox loop":   func() (any, error) { return dispatcher.sandbox("loop/x") },
+		"read missing": func() (any, error) {
+			return dispatcher.readFile(ReadFileArguments{Path: "missing.txt"})
+		},
+		"list missing": func() (any, error) {
+			return dispatcher.listFiles(ListFilesArguments{Path: "missing"})
+		},
+		"write under file": func() (any, error) {
+			return dispatcher.writeFile(WriteFileArguments{Path: "file.txt/new", Contents: "x"})
+		},
+		"move missing": func() (any, error) {
+			return dispatcher.moveFile(MoveFileArguments{From: "missing.txt", To: "new.txt"})
+		},
+		"remove missing": func() (any, error) {
+			return dispatcher.removeFile(RemoveFileArguments{Path: "missing.txt"})
+		},
+	}
+	for name, call := range calls {
+		_, err := call()
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+			continue
+		}
+
+		message := karma.Flatten(err).Error()
+		if strings.Contains(message, dispatcher.cwd) || strings.Contains(message, outside) {
+			t.Errorf("%s: error exposes host paths: %s", name, message)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/reconquest/karma-go"
+)
+
+// ShellOptions configure the shell_exec tool.
+//
+//	shell:
+//	  timeout: 300
+//	  env: [PATH, HOME, GOFLAGS, "LC_*"]
+//	  max_output: 65536
+//	  confirm: true
+type ShellOptions struct {
+	// Timeout is the default and the maximum timeout of a command in
+	// seconds
+	Timeout int `yaml:"timeout"`
+
+	// Env lists globs of environment variables passed to commands, others
+	// are not passed
+	Env []string `yaml:"env"`
+
+	// MaxOutput limits bytes of the combined output returned to the model,
+	// the middle of longer output is cut
+	MaxOutput int `yaml:"max_output"`
+
+	// Confirm asks the user before each command even with --yes
+	Confirm bool `yaml:"confirm"`
+}
+
+var defaultShellOptions = ShellOptions{
+	Timeout: 120,
+	Env: []string{
+		"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TMPDIR",
+		"LANG", "LC_*",
+	},
+	MaxOutput: 16 << 10,
+}
+
+// shellWaitDelay is how long output is read after the command is killed or
+// exited, background processes may keep the output open.
+const shellWaitDelay = 5 * time.Second
+
+func (options ShellOptions) merge(other ShellOptions) ShellOptions {
+	if other.Timeout != 0 {
+		options.Timeout = other.Timeout
+	}
+
+	if other.Env != nil {
+		options.Env = other.Env
+	}
+
+	if other.MaxOutput != 0 {
+		options.MaxOutput = other.MaxOutput
+	}
+
+	if other.Confirm {
+		options.Confirm = true
+	}
+
+	return options
+}
+
+// environ returns variables of the current environment allowed by the
+// options.
+func (options ShellOptions) environ() []string {
+	env := []string{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+
+		for _, pattern := range options.Env {
+			matched, _ := path.Match(pattern, name)
+			if matched {
+				env = append(env, variable)
+				break
+			}
+		}
+	}
+
+	return env
+}
+
+type ShellExecArguments struct {
+	Command string `json:"command"`
+	Dir     string `json:"dir,omitempty"`
+	Timeout int    `json:"timeout,omitempty"`
+}
+
+func (args ShellExecArguments) Paths() []string {
+	return []string{args.Dir}
+}
+
+func (args ShellExecArguments) String() string {
+	return args.Command
+}
+
+type CommandResult struct {
+	ExitCode  int    `json:"exit_code"`
+	Output    string `json:"output"`
+	TimedOut  bool   `json:"timed_out,omitempty"`
+	Truncated int    `json:"truncated_bytes,omitempty"`
+}
+
+func (dispatcher *Dispatcher) shellExec(args ShellExecArguments) (any, error) {
+	if dispatcher.overlay != nil {
+		return nil, errors.New("commands can't be simulated in dry-run mode")
+	}
+
+	if strings.TrimSpace(args.Command) == "" {
+		return nil, errors.New("command is empty")
+	}
+
+	dir, err := dispatcher.sandbox(args.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := dispatcher.shell.Timeout
+	if args.Timeout > 0 && args.Timeout < timeout {
+		timeout = args.Timeout
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Duration(timeout)*time.Second,
+	)
+	defer cancel()
+
+	cmd := shellCommand(ctx, args.Command)
+	cmd.Dir = dir
+	cmd.Env = dispatcher.shell.environ()
+	cmd.WaitDelay = shellWaitDelay
+
+	output := bytes.NewBuffer(nil)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	result := CommandResult{}
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		result.TimedOut = true
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil && !errors.Is(err, exec.ErrWaitDelay):
+		return nil, karma.Format(err, "run command")
+	}
+
+	result.Output, result.Truncated = truncateMiddle(
+		output.String(),
+		dispatcher.shell.MaxOutput,
+	)
+
+	if result.TimedOut {
+		result.Output += fmt.Sprintf("\n[killed after %d seconds]", timeout)
+	}
+
+	return result, nil
+}
+
+// truncateMiddle cuts the middle of the text to fit the limit, the end of
+// the output usually has errors and the beginning tells what was run.
+func truncateMiddle(text string, limit int) (string, int) {
+	if limit <= 0 || len(text) <= limit {
+		return text, 0
+	}
+
+	head := limit / 4
+	tail := len(text) - (limit - head)
+
+	// both cuts are moved to character boundaries within the limit
+	for head > 0 && !utf8.RuneStart(text[head]) {
+		head--
+	}
+
+	for tail < len(text) && !utf8.RuneStart(text[tail]) {
+		tail++
+	}
+
+	cut := tail - head
+
+	return fmt.Sprintf(
+		"%s\n[... %d bytes truncated ...]\n%s",
+		text[:head],
+		cut,
+		text[tail:],
+	), cut
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestDispatcher_ShellExec(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+	dispatcher.shell.MaxOutput = 100
+
+	t.Setenv("AIGHT_SECRET", "secret")
+	t.Setenv("AIGHT_ALLOWED", "allowed")
+
+	dispatcher.shell.Env = append(dispatcher.shell.Env, "AIGHT_ALLOW*")
+
+	err := os.Mkdir(filepath.Join(dispatcher.cwd, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := dispatcher.shellExec(ShellExecArguments{
+		Command: `pwd; echo "$AIGHT_ALLOWED:$AIGHT_SECRET"`,
+		Dir:     "sub",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := value.(CommandResult)
+	if result.ExitCode != 0 || !strings.HasSuffix(result.Output, "/sub\nallowed:\n") {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	value, err = dispatcher.shellExec(ShellExecArguments{
+		Command: `for i in $(seq 1 100); do echo line $i; done; exit 1`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result = value.(CommandResult)
+	if result.ExitCode != 1 || result.Truncated == 0 ||
+		!strings.HasPrefix(result.Output, "line 1\n") ||
+		!strings.HasSuffix(result.Output, "line 100\n") {
+		t.Errorf("unexpected truncated result: %#v", result)
+	}
+
+	_, err = dispatcher.shellExec(ShellExecArguments{Command: "true", Dir: ".."})
+	if err == nil {
+		t.Error("expected dir outside of the workspace to be rejected")
+	}
+}
+
+func TestDispatcher_ShellExecTimeout(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	started := time.Now()
+
+	// the background sleep keeps the output open unless the group is killed
+	value, err := dispatcher.shellExec(ShellExecArguments{
+		Command: "sleep 30 & echo started; sleep 30",
+		Timeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := value.(CommandResult)
+	if !result.TimedOut || !strings.HasPrefix(result.Output, "started\n") {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	if elapsed := time.Since(started); elapsed > shellWaitDelay {
+		t.Errorf("command is not killed in time: %s", elapsed)
+	}
+}
+
+func TestDispatcher_ApproveConfirm(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+	dispatcher.yes = true
+	dispatcher.confirm["shell_exec"] = true
+
+	approver := &denyApprover{}
+	dispatcher.approver = approver
+
+	err := dispatcher.approve(ToolUse{Name: "fs_write", Input: []byte(`{}`)})
+	if err != nil {
+		t.Errorf("fs_write must be allowed by --yes, got %v", err)
+	}
+
+	err = dispatcher.approve(ToolUse{Name: "shell_exec", Input: []byte(`{"command":"make"}`)})
+	if err == nil {
+		t.Error("shell_exec must be confirmed even with --yes")
+	}
+
+	if len(approver.previews) != 1 {
+		t.Errorf("expected one question, got %d", len(approver.previews))
+	}
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	text := strings.Repeat("é", 100)
+
+	for limit := 10; limit < 20; limit++ {
+		truncated, cut := truncateMiddle(text, limit)
+
+		head, tail, _ := strings.Cut(truncated, "\n[...")
+		_, tail, _ = strings.Cut(tail, "...]\n")
+
+		if !utf8.ValidString(truncated) || len(head)+len(tail) > limit ||
+			len(head)+len(tail)+cut != len(text) {
+			t.Errorf("limit %d: unexpected %q, %d bytes cut", limit, truncated, cut)
+		}
+	}
+}
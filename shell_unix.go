@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs the command by sh in its own process group, so the
+// whole group is killed when the context is done.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	return cmd
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// StreamHandler receives parts of the completion while the provider is
+// still assembling it.
+type StreamHandler interface {
+	Text(delta string)
+	ToolUse(id string, name string)
+	ToolInput(delta string)
+	Stop()
+}
+
+// TerminalStream prints the streamed completion as it arrives.
+type TerminalStream struct {
+	writer io.Writer
+	mutex  sync.Mutex
+	open   bool
+}
+
+func NewTerminalStream(writer io.Writer) *TerminalStream {
+	return &TerminalStream{writer: writer}
+}
+
+func (stream *TerminalStream) Text(delta string) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if !stream.open {
+		fmt.Fprintf(stream.writer, "{%s} ", color.RedString("assistant"))
+		stream.open = true
+	}
+
+	fmt.Fprint(stream.writer, delta)
+}
+
+func (stream *TerminalStream) ToolUse(id string, name string) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if stream.open {
+		fmt.Fprintln(stream.writer)
+	}
+
+	fmt.Fprintf(stream.writer, "{%s} %s: ", color.CyanString("assistant"), name)
+	stream.open = true
+}
+
+func (stream *TerminalStream) ToolInput(delta string) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	fmt.Fprint(stream.writer, color.New(color.Faint).Sprint(delta))
+}
+
+func (stream *TerminalStream) Stop() {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if stream.open {
+		fmt.Fprintln(stream.writer)
+		stream.open = false
+	}
+}
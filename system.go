@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	systemPromptIntro = `You are aight, an AI assistant working in a sandboxed workspace on the ` +
+		`user's machine. You act through tools:`
+
+	systemPromptRules = `Every path is relative to the root of the workspace, absolute paths and ` +
+		`paths leading outside of the workspace are rejected. Tools of one reply are ` +
+		`executed concurrently, so don't combine calls that depend on each other ` +
+		`in a single reply.
+
+Explore the workspace before changing it, prefer small precise edits over ` +
+		`rewriting whole files, and verify the result of your changes. Be concise.`
+)
+
+// toolGuides tell when to use the tools, the built-in prompt has guides of
+// the registered tools only, e.g. none of the changing ones in read-only
+// mode.
+var toolGuides = []struct {
+	tools []string
+	guide string
+}{
+	{
+		[]string{"fs_tree", "fs_glob"},
+		"fs_tree shows the layout of directories and fs_glob finds files by ** patterns.",
+	},
+	{
+		[]string{"fs_grep"},
+		"fs_grep searches contents of files by a regular expression, use it to find code " +
+			"instead of reading files one by one.",
+	},
+	{
+		[]string{"fs_list", "fs_read"},
+		"fs_list lists a directory and fs_read reads a file with numbered lines, " +
+			"read only the lines you need of large files with offset and limit.",
+	},
+	{
+		[]string{"go_symbols", "go_definition", "go_references", "go_source"},
+		"In Go code prefer go_symbols, go_definition, go_references and go_source over " +
+			"fs_grep and fs_read, they find declarations and their usages exactly.",
+	},
+	{
+		[]string{"fs_edit", "fs_patch", "fs_write", "fs_move", "fs_remove"},
+		"Change existing files with fs_edit, use fs_patch for changes of several files " +
+			"and fs_write for new files only. fs_move and fs_remove move and remove files.",
+	},
+	{
+		[]string{"sql_query"},
+		"sql_query reads SQLite databases.",
+	},
+	{
+		[]string{"sql_exec"},
+		"sql_exec changes SQLite databases.",
+	},
+	{
+		[]string{"git_status", "git_diff", "git_log", "git_show"},
+		"git_status, git_diff, git_log and git_show inspect changes and history of the repository.",
+	},
+	{
+		[]string{"shell_exec"},
+		"shell_exec runs commands, use it to build and test changes rather than to read " +
+			"or edit files, the file tools are faster and safer for that.",
+	},
+	{
+		[]string{"python_execute"},
+		"python_execute runs Python scripts without network, e.g. for calculations.",
+	},
+}
+
+// defaultSystemPrompt returns the built-in system prompt describing the
+// given tools.
+func defaultSystemPrompt(tools []ToolDefinition) string {
+	registered := map[string]bool{}
+	for _, tool := range tools {
+		registered[tool.Name] = true
+	}
+
+	guides := []string{}
+	for _, guide := range toolGuides {
+		for _, name := range guide.tools {
+			if registered[name] {
+				guides = append(guides, "- "+guide.guide)
+				break
+			}
+		}
+	}
+
+	if len(guides) == 0 {
+		guides = append(guides, "- no tools are available, answer from the conversation only.")
+	}
+
+	return systemPromptIntro + "\n\n" + strings.Join(guides, "\n") + "\n\n" + systemPromptRules
+}
+
+// instructionFiles are appended to the system prompt if they exist in the
+// working directory.
+var instructionFiles = []string{
+	"AIGHT.md",
+	filepath.Join(stateDir, "instructions.md"),
+}
+
+// LoadSystemPrompt returns the built-in system prompt for the given tools
+// or contents of the given file with workspace instructions appended.
+func LoadSystemPrompt(cwd string, path string, tools []ToolDefinition) (string, error) {
+	prompt := defaultSystemPrompt(tools)
+
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return "", karma.Format(err, "read system prompt: %s", path)
+		}
+
+		prompt = string(contents)
+	}
+
+	parts := []string{strings.TrimSpace(prompt)}
+
+	for _, name := range instructionFiles {
+		contents, err := os.ReadFile(filepath.Join(cwd, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return "", karma.Format(err, "read instructions: %s", name)
+		}
+
+		instructions := strings.TrimSpace(string(contents))
+		if instructions == "" {
+			continue
+		}
+
+		parts = append(
+			parts,
+			"Instructions of the workspace from "+name+":\n\n"+instructions,
+		)
+	}
+
+	return strings.Join(parts, "\n\n"), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSystemPrompt(t *testing.T) {
+	cwd := t.TempDir()
+
+	dispatcher := NewDispatcher(cwd, "model", false, nil)
+
+	prompt, err := LoadSystemPrompt(cwd, "", dispatcher.tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prompt != dispatcher.system {
+		t.Errorf("expected built-in prompt, got %q", prompt)
+	}
+
+	err = os.WriteFile(filepath.Join(cwd, "AIGHT.md"), []byte("Use tabs.\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(filepath.Join(cwd, stateDir), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(cwd, stateDir, "instructions.md"), []byte("Run tests."), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	override := filepath.Join(t.TempDir(), "system.txt")
+	err = os.WriteFile(override, []byte("Custom prompt."), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompt, err = LoadSystemPrompt(cwd, override, dispatcher.tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(prompt, "Custom prompt.\n\n") ||
+		!strings.Contains(prompt, "from AIGHT.md:\n\nUse tabs.") ||
+		!strings.HasSuffix(prompt, "Run tests.") {
+		t.Errorf("unexpected prompt: %q", prompt)
+	}
+}
+
+func TestDefaultSystemPrompt(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	for _, guide := range []string{"fs_grep searches", "go_references", "fs_edit", "git_diff", "shell_exec"} {
+		if !strings.Contains(dispatcher.system, guide) {
+			t.Errorf("expected the prompt to describe %s:\n%s", guide, dispatcher.system)
+		}
+	}
+
+	dispatcher.DisableMutating()
+
+	prompt := defaultSystemPrompt(dispatcher.tools)
+	if strings.Contains(prompt, "fs_edit") || strings.Contains(prompt, "shell_exec") {
+		t.Errorf("expected changing tools to be left out in read-only mode:\n%s", prompt)
+	}
+
+	if !strings.Contains(prompt, "fs_grep") || !strings.Contains(prompt, "sql_query") {
+		t.Errorf("expected read tools to be described:\n%s", prompt)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	defaultThreadName = "default"
+	legacyThreadFile  = "thread.aight.json"
+	stateDir          = ".aight"
+)
+
+var (
+	reThreadName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+)
+
+// ThreadStore keeps named threads of the workspace in .aight/threads.
+type ThreadStore struct {
+	dir string
+}
+
+type ThreadInfo struct {
+	Name     string
+	Messages int
+	Modified time.Time
+}
+
+func NewThreadStore(cwd string) *ThreadStore {
+	return &ThreadStore{
+		dir: filepath.Join(cwd, stateDir, "threads"),
+	}
+}
+
+func (store *ThreadStore) path(name string) (string, error) {
+	if !reThreadName.MatchString(name) {
+		return "", fmt.Errorf(
+			"invalid thread name %q: only letters, digits, '.', '_' and '-' are allowed",
+			name,
+		)
+	}
+
+	return filepath.Join(store.dir, name+".json"), nil
+}
+
+func (store *ThreadStore) Exists(name string) (bool, error) {
+	path, err := store.path(name)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Read returns the thread or empty thread if it doesn't exist yet.
+func (store *ThreadStore) Read(name string) ([]Message, error) {
+	path, err := store.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	thread := []Message{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return thread, nil
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &thread)
+	if err != nil {
+		return nil, karma.Format(err, "decode thread: %s", path)
+	}
+
+	return thread, nil
+}
+
+func (store *ThreadStore) Save(name string, thread []Message) error {
+	path, err := store.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(store.dir, 0755)
+	if err != nil {
+		return karma.Format(err, "create threads directory: %s", store.dir)
+	}
+
+	data, err := json.MarshalIndent(thread, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func (store *ThreadStore) List() ([]ThreadInfo, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, karma.Format(err, "read threads directory: %s", store.dir)
+	}
+
+	threads := []ThreadInfo{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, karma.Format(err, "get file info")
+		}
+
+		thread, err := store.Read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		threads = append(threads, ThreadInfo{
+			Name:     name,
+			Messages: len(thread),
+			Modified: info.ModTime(),
+		})
+	}
+
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].Name < threads[j].Name
+	})
+
+	return threads, nil
+}
+
+func (store *ThreadStore) Delete(name string) error {
+	path, err := store.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("thread %q does not exist", name)
+		}
+
+		return karma.Format(err, "remove thread")
+	}
+
+	return nil
+}
+
+func (store *ThreadStore) Rename(name string, newName string) error {
+	from, err := store.path(name)
+	if err != nil {
+		return err
+	}
+
+	to, err := store.path(newName)
+	if err != nil {
+		return err
+	}
+
+	exists, err := store.Exists(newName)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return fmt.Errorf("thread %q already exists", newName)
+	}
+
+	err = os.Rename(from, to)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("thread %q does not exist", name)
+		}
+
+		return karma.Format(err, "rename thread")
+	}
+
+	return nil
+}
+
+// Migrate moves thread.aight.json of older versions into the store as the
+// default thread.
+func (store *ThreadStore) Migrate(cwd string) (bool, error) {
+	legacy := filepath.Join(cwd, legacyThreadFile)
+
+	_, err := os.Stat(legacy)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	exists, err := store.Exists(defaultThreadName)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(legacy)
+	if err != nil {
+		return false, err
+	}
+
+	// the legacy file keeps tool blocks in the flat Anthropic layout, it is
+	// converted by decoding it and saving it in the current format
+	thread := []Message{}
+	err = json.Unmarshal(data, &thread)
+	if err != nil {
+		return false, karma.Format(err, "decode legacy thread: %s", legacy)
+	}
+
+	err = store.Save(defaultThreadName, thread)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Remove(legacy)
+	if err != nil {
+		return false, karma.Format(err, "remove legacy thread: %s", legacy)
+	}
+
+	return true, nil
+}
+
+func printThreads(writer io.Writer, threads []ThreadInfo) {
+	for _, thread := range threads {
+		fmt.Fprintf(
+			writer,
+			"%s\t%d messages\t%s\n",
+			thread.Name,
+			thread.Messages,
+			thread.Modified.Format(time.DateTime),
+		)
+	}
+}
+
+func printThread(writer io.Writer, thread []Message) {
+	for i, message := range thread {
+		for _, content := range message.Content {
+			role := message.Role
+
+			var text string
+			switch content.Type {
+			case ContentTypeText:
+				text = content.Text
+			case ContentTypeToolUse:
+				if content.ToolUse == nil {
+					text = "(missing tool call)"
+					break
+				}
+
+				input := bytes.NewBuffer(nil)
+				if json.Compact(input, content.ToolUse.Input) != nil {
+					input.Write(content.ToolUse.Input)
+				}
+
+				text = fmt.Sprintf("%s: %s", content.ToolUse.Name, input)
+			case ContentTypeToolResult:
+				role = "tool"
+				if content.ToolResult == nil {
+					text = "(missing tool result)"
+					break
+				}
+
+				text = content.ToolResult.Content
+			}
+
+			fmt.Fprintf(writer, "[%d] {%s} %s\n", i+1, role, text)
+		}
+	}
+}
+
+// truncateThread returns the first count messages of the thread. An
+// assistant message at the end that has tool calls is dropped as well,
+// because its tool results are cut off.
+func truncateThread(thread []Message, count int) ([]Message, error) {
+	if count < 0 || count > len(thread) {
+		return nil, fmt.Errorf(
+			"turn %d is out of range, the thread has %d messages",
+			count,
+			len(thread),
+		)
+	}
+
+	result := append([]Message{}, thread[:count]...)
+
+	for len(result) > 0 {
+		last := result[len(result)-1]
+
+		completion := Completion{Content: last.Content}
+		if last.Role != RoleAssistant || len(completion.ToolUses()) == 0 {
+			break
+		}
+
+		result = result[:len(result)-1]
+	}
+
+	return result, nil
+}
+
+// Rewind drops every message of the thread after the given turn.
+func (store *ThreadStore) Rewind(name string, turn int) ([]Message, error) {
+	thread, err := store.readExisting(name)
+	if err != nil {
+		return nil, err
+	}
+
+	thread, err = truncateThread(thread, turn)
+	if err != nil {
+		return nil, err
+	}
+
+	return thread, store.Save(name, thread)
+}
+
+// Fork copies the thread up to the given turn into a new thread.
+func (store *ThreadStore) Fork(name string, turn int, newName string) ([]Message, error) {
+	exists, err := store.Exists(newName)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, fmt.Errorf("thread %q already exists", newName)
+	}
+
+	thread, err := store.readExisting(name)
+	if err != nil {
+		return nil, err
+	}
+
+	thread, err = truncateThread(thread, turn)
+	if err != nil {
+		return nil, err
+	}
+
+	return thread, store.Save(newName, thread)
+}
+
+func (store *ThreadStore) readExisting(name string) ([]Message, error) {
+	exists, err := store.Exists(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("thread %q does not exist", name)
+	}
+
+	return store.Read(name)
+}
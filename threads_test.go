@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestThreadStore(t *testing.T) {
+	store := NewThreadStore(t.TempDir())
+
+	err := store.Save("first", []Message{NewTextMessage(RoleUser, "hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Save("second", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Rename("first", "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Rename("second", "renamed")
+	if err == nil {
+		t.Errorf("expected error when renaming into existing thread")
+	}
+
+	threads, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 2 || threads[0].Name != "renamed" || threads[0].Messages != 1 {
+		t.Fatalf("unexpected threads: %#v", threads)
+	}
+
+	err = store.Delete("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Delete("second")
+	if err == nil {
+		t.Errorf("expected error when deleting missing thread")
+	}
+
+	for _, name := range []string{"", "../escape", "a/b", ".hidden"} {
+		_, err := store.Read(name)
+		if err == nil {
+			t.Errorf("expected error for thread name %q", name)
+		}
+	}
+}
+
+func TestThreadStore_Migrate(t *testing.T) {
+	cwd := t.TempDir()
+
+	err := os.WriteFile(
+		filepath.Join(cwd, legacyThreadFile),
+		[]byte(`[
+			{"role":"user","content":[{"type":"text","text":"legacy"}]},
+			{"role":"assistant","content":[
+				{"type":"tool_use","id":"toolu_1","name":"fs_list","input":{"path":"."}}
+			]},
+			{"role":"user","content":[
+				{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"a.txt"}]}
+			]}
+		]`),
+		0644,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewThreadStore(cwd)
+
+	migrated, err := store.Migrate(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !migrated {
+		t.Fatalf("expected thread to be migrated")
+	}
+
+	thread, err := store.Read(defaultThreadName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(thread) != 3 || thread[0].Content[0].Text != "legacy" {
+		t.Fatalf("unexpected thread: %#v", thread)
+	}
+
+	toolUse := thread[1].Content[0].ToolUse
+	if toolUse == nil || toolUse.ID != "toolu_1" || toolUse.Name != "fs_list" {
+		t.Errorf("unexpected tool use: %#v", toolUse)
+	}
+
+	toolResult := thread[2].Content[0].ToolResult
+	if toolResult == nil || toolResult.ToolUseID != "toolu_1" || toolResult.Content != "a.txt" {
+		t.Errorf("unexpected tool result: %#v", toolResult)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cwd, ".aight", "threads", defaultThreadName+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"tool_result": {`) {
+		t.Errorf("expected thread to be saved in the current format:\n%s", data)
+	}
+
+	output := bytes.NewBuffer(nil)
+	printThread(output, thread)
+
+	if !strings.Contains(output.String(), `{assistant} fs_list: {"path":"."}`) ||
+		!strings.Contains(output.String(), "{tool} a.txt") {
+		t.Errorf("unexpected output:\n%s", output)
+	}
+
+	if _, err := os.Stat(filepath.Join(cwd, legacyThreadFile)); !os.IsNotExist(err) {
+		t.Errorf("expected legacy thread file to be moved, got %v", err)
+	}
+}
+
+func TestTruncateThread(t *testing.T) {
+	thread := []Message{
+		NewTextMessage(RoleUser, "list files"),
+		{
+			Role: RoleAssistant,
+			Content: []Content{
+				NewTextContent("Sure."),
+				NewToolUseContent("call_1", "fs_list", []byte(`{"path":"."}`)),
+			},
+		},
+		{
+			Role:    RoleUser,
+			Content: []Content{NewToolResultContent("call_1", "[]", false)},
+		},
+		NewTextMessage(RoleAssistant, "It is empty."),
+	}
+
+	tests := []struct {
+		turn     int
+		expected int
+	}{
+		{turn: 4, expected: 4},
+		{turn: 3, expected: 3},
+		{turn: 2, expected: 1},
+		{turn: 1, expected: 1},
+		{turn: 0, expected: 0},
+	}
+
+	for _, test := range tests {
+		result, err := truncateThread(thread, test.turn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != test.expected {
+			t.Errorf("turn %d: expected %d messages, got %d", test.turn, test.expected, len(result))
+		}
+	}
+
+	_, err := truncateThread(thread, 5)
+	if err == nil {
+		t.Errorf("expected error for out of range turn")
+	}
+}
+
+func TestThreadStore_Fork(t *testing.T) {
+	store := NewThreadStore(t.TempDir())
+
+	err := store.Save("main", []Message{
+		NewTextMessage(RoleUser, "one"),
+		NewTextMessage(RoleAssistant, "two"),
+		NewTextMessage(RoleUser, "three"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Fork("main", 2, "alternative")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Fork("main", 1, "alternative")
+	if err == nil {
+		t.Errorf("expected error when forking into existing thread")
+	}
+
+	_, err = store.Rewind("main", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, _ := store.Read("main")
+	alternative, _ := store.Read("alternative")
+	if len(original) != 1 || len(alternative) != 2 {
+		t.Errorf("unexpected threads: %d and %d messages", len(original), len(alternative))
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	treeDefaultDepth   = 3
+	treeDefaultEntries = 300
+	treeMaxEntries     = 3000
+
+	// treeMaxDirEntries is the number of entries shown of one directory, so
+	// a directory with many files doesn't hide the rest of the tree
+	treeMaxDirEntries = 50
+)
+
+type TreeFilesArguments struct {
+	Path string `json:"path"`
+
+	// MaxDepth is the number of directory levels shown
+	MaxDepth int `json:"max_depth,omitempty"`
+
+	// Include and Exclude are globs of file paths, a glob without a slash
+	// matches the file name
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	MaxEntries int `json:"max_entries,omitempty"`
+
+	// Format is "text" for the indented list or "json"
+	Format string `json:"format,omitempty"`
+}
+
+func (args TreeFilesArguments) Paths() []string {
+	return []string{args.Path}
+}
+
+type TreeNode struct {
+	Name     string      `json:"name"`
+	Dir      bool        `json:"dir,omitempty"`
+	Size     int64       `json:"size,omitempty"`
+	Link     string      `json:"link,omitempty"`
+	Children []*TreeNode `json:"children,omitempty"`
+
+	// More is the number of entries of the directory that are not shown
+	// because of limits
+	More int `json:"more,omitempty"`
+}
+
+type TreeResult struct {
+	Tree    string    `json:"tree,omitempty"`
+	Root    *TreeNode `json:"root,omitempty"`
+	Entries int       `json:"entries"`
+	Omitted int       `json:"omitted,omitempty"`
+}
+
+func (dispatcher *Dispatcher) treeFiles(args TreeFilesArguments) (any, error) {
+	if args.Format != "" && args.Format != "text" && args.Format != "json" {
+		return nil, fmt.Errorf("format must be text or json, got %q", args.Format)
+	}
+
+	for _, patterns := range [][]string{args.Include, args.Exclude} {
+		for _, pattern := range patterns {
+			if err := checkGlob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %s", pattern, err)
+			}
+		}
+	}
+
+	depth := args.MaxDepth
+	if depth <= 0 {
+		depth = treeDefaultDepth
+	}
+
+	limit := args.MaxEntries
+	if limit <= 0 {
+		limit = treeDefaultEntries
+	}
+
+	limit = min(limit, treeMaxEntries)
+
+	root, err := dispatcher.sandbox(args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := filepath.Rel(dispatcher.cwd, root)
+	if err != nil {
+		return nil, err
+	}
+
+	start = filepath.ToSlash(start)
+
+	result := TreeResult{}
+
+	nodes := map[string]*TreeNode{}
+
+	err = dispatcher.walkWorkspace(root, func(name string, entry fs.DirEntry) error {
+		if name == start {
+			if !entry.IsDir() {
+				return fmt.Errorf("%s is not a directory", args.Path)
+			}
+
+			result.Root = &TreeNode{Name: args.Path, Dir: true}
+			nodes[name] = result.Root
+
+			return nil
+		}
+
+		parent := nodes[path.Dir(name)]
+
+		skip := func() error {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// include globs apply to files, so directories are walked to find them
+		include := args.Include
+		if entry.IsDir() {
+			include = nil
+		}
+
+		if !matchGlobs(name, include, args.Exclude) {
+			return skip()
+		}
+
+		if dispatcher.policy.Check("fs_tree", []string{name}) != nil {
+			return skip()
+		}
+
+		level := strings.Count(name, "/") + 1
+		if start != "." {
+			level -= strings.Count(start, "/") + 1
+		}
+
+		if level > depth || len(parent.Children) >= treeMaxDirEntries || result.Entries >= limit {
+			parent.More++
+			result.Omitted++
+
+			return skip()
+		}
+
+		node := &TreeNode{Name: entry.Name(), Dir: entry.IsDir()}
+
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			node.Link, _ = os.Readlink(filepath.Join(dispatcher.cwd, filepath.FromSlash(name)))
+
+		case !entry.IsDir():
+			info, err := entry.Info()
+			if err == nil {
+				node.Size = info.Size()
+			}
+		}
+
+		parent.Children = append(parent.Children, node)
+		result.Entries++
+
+		if entry.IsDir() {
+			nodes[name] = node
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// with include globs directories without matching files are noise
+	if len(args.Include) > 0 {
+		result.Entries -= pruneTree(result.Root)
+	}
+
+	if args.Format == "json" {
+		return result, nil
+	}
+
+	var buffer strings.Builder
+
+	buffer.WriteString(strings.TrimSuffix(args.Path, "/") + "/\n")
+	writeTree(&buffer, result.Root, "  ")
+
+	result.Tree = buffer.String()
+	result.Root = nil
+
+	return result, nil
+}
+
+// pruneTree removes directories without files and returns the number of
+// removed entries.
+func pruneTree(node *TreeNode) int {
+	removed := 0
+
+	children := node.Children[:0]
+	for _, child := range node.Children {
+		if child.Dir {
+			removed += pruneTree(child)
+
+			if len(child.Children) == 0 && child.More == 0 {
+				removed++
+				continue
+			}
+		}
+
+		children = append(children, child)
+	}
+
+	node.Children = children
+
+	return removed
+}
+
+func writeTree(buffer *strings.Builder, node *TreeNode, indent string) {
+	for _, child := range node.Children {
+		buffer.WriteString(indent + child.Name)
+
+		switch {
+		case child.Dir:
+			buffer.WriteString("/")
+		case child.Link != "":
+			buffer.WriteString(" -> " + child.Link)
+		}
+
+		buffer.WriteString("\n")
+
+		if child.Dir {
+			writeTree(buffer, child, indent+"  ")
+		}
+	}
+
+	if node.More > 0 {
+		fmt.Fprintf(buffer, "%s... %d more\n", indent, node.More)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDispatcher_TreeFiles(t *testing.T) {
+	dispatcher := NewDispatcher(t.TempDir(), "model", false, nil)
+
+	files := map[string]string{
+		".gitignore":                 "*.log\n",
+		"debug.log":                  "",
+		"main.go":                    "package main\n",
+		"README.md":                  "# readme\n",
+		"node_modules/left/index.js": "",
+		"a/b/c/d.go":                 "package d\n",
+		"a/b/c/e.txt":                "",
+		"docs/guide.md":              "",
+	}
+	for i := 0; i < treeMaxDirEntries+2; i++ {
+		files[fmt.Sprintf("many/%03d.txt", i)] = ""
+	}
+
+	writeFiles(t, dispatcher.cwd, files)
+
+	tests := []struct {
+		args     TreeFilesArguments
+		expected string
+	}{
+		{
+			TreeFilesArguments{Path: ".", MaxDepth: 2, Exclude: []string{"many"}},
+			"./\n  .gitignore\n  README.md\n  a/\n    b/\n      ... 1 more\n" +
+				"  docs/\n    guide.md\n  main.go\n",
+		},
+		{
+			TreeFilesArguments{Path: ".", Include: []string{"*.go"}, MaxDepth: 5},
+			"./\n  a/\n    b/\n      c/\n        d.go\n  main.go\n",
+		},
+		{
+			TreeFilesArguments{Path: "many", MaxEntries: 3},
+			"many/\n  000.txt\n  001.txt\n  002.txt\n  ... 49 more\n",
+		},
+	}
+	for _, test := range tests {
+		result, err := dispatcher.treeFiles(test.args)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", test.args, err)
+			continue
+		}
+
+		if tree := result.(TreeResult).Tree; tree != test.expected {
+			t.Errorf("%+v: expected\n%s\ngot\n%s", test.args, test.expected, tree)
+		}
+	}
+
+	result, err := dispatcher.treeFiles(TreeFilesArguments{Path: "many", Format: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := result.(TreeResult)
+	if len(tree.Root.Children) != treeMaxDirEntries || tree.Root.More != 2 || tree.Omitted != 2 {
+		t.Errorf("expected %d entries and 2 more, got %d and %d",
+			treeMaxDirEntries, len(tree.Root.Children), tree.Root.More)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	// dir is the slash-separated directory of the .gitignore file relative
+	// to the workspace, empty for the root
+	dir string
+
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are patterns of all .gitignore files from the workspace root
+// down to the walked directory, the last matching one wins.
+type ignoreRules []ignoreRule
+
+// load appends patterns of the .gitignore file of the directory.
+func (rules ignoreRules) load(root string, dir string) (ignoreRules, error) {
+	// siblings share the rules of the parent
+	rules = rules[:len(rules):len(rules)]
+
+	fd, err := os.Open(filepath.Join(root, filepath.FromSlash(dir), ".gitignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rules, nil
+		}
+
+		return rules, karma.Format(err, "open .gitignore")
+	}
+
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{dir: dir}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+
+		line = strings.TrimPrefix(line, `\`)
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// ignored reports whether the slash-separated path relative to the
+// workspace is ignored.
+func (rules ignoreRules) ignored(name string, dir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !dir {
+			continue
+		}
+
+		relative := name
+		if rule.dir != "" {
+			if !strings.HasPrefix(name, rule.dir+"/") {
+				continue
+			}
+
+			relative = strings.TrimPrefix(name, rule.dir+"/")
+		}
+
+		if matchGlob(rule.pattern, relative) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+// skipDirs are never walked, they are huge and rarely interesting.
+var skipDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	stateDir:       true,
+}
+
+// walkWorkspace walks the sandboxed directory calling fn with paths relative
+// to the workspace in lexical order like filepath.WalkDir, in dry-run mode
+// with files of the overlay. The .git, node_modules and state directories
+// and files ignored by .gitignore files are skipped, symlinks are not
+// followed.
+func (dispatcher *Dispatcher) walkWorkspace(
+	root string,
+	fn func(name string, entry fs.DirEntry) error,
+) error {
+	start, err := filepath.Rel(dispatcher.cwd, root)
+	if err != nil {
+		return err
+	}
+
+	start = filepath.ToSlash(start)
+
+	// .gitignore files of the parent directories apply too
+	rules := ignoreRules{}
+	if start != "." {
+		dirs := strings.Split(start, "/")
+		for i := range dirs {
+			rules, err = rules.load(dispatcher.cwd, path.Join(dirs[:i]...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	entry, err := dispatcher.statEntry(start)
+	if err != nil {
+		return err
+	}
+
+	err = dispatcher.walkEntry(start, entry, rules, true, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+
+	return err
+}
+
+// walkEntry calls fn for the entry and walks the directory with the rules
+// of its parents.
+func (dispatcher *Dispatcher) walkEntry(
+	name string,
+	entry fs.DirEntry,
+	rules ignoreRules,
+	root bool,
+	fn func(name string, entry fs.DirEntry) error,
+) error {
+	if !root {
+		if entry.IsDir() && skipDirs[entry.Name()] {
+			return nil
+		}
+
+		if rules.ignored(name, entry.IsDir()) {
+			return nil
+		}
+	}
+
+	err := fn(name, entry)
+	if err == filepath.SkipDir && entry.IsDir() {
+		return nil
+	}
+
+	if err != nil || !entry.IsDir() {
+		return err
+	}
+
+	dir := name
+	if dir == "." {
+		dir = ""
+	}
+
+	rules, err = rules.load(dispatcher.cwd, dir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := dispatcher.readDir(name)
+	if err != nil {
+		if root {
+			return karma.Format(relativeError(dispatcher.cwd, err), "read dir: %s", name)
+		}
+
+		return nil
+	}
+
+	for _, entry := range entries {
+		err := dispatcher.walkEntry(path.Join(name, entry.Name()), entry, rules, false, fn)
+		if err == filepath.SkipDir {
+			// like filepath.WalkDir, the rest of the directory is skipped
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statEntry returns the entry of the slash-separated path of the workspace
+// without following symlinks, in dry-run mode changed by the overlay.
+func (dispatcher *Dispatcher) statEntry(name string) (fs.DirEntry, error) {
+	path := filepath.Join(dispatcher.cwd, filepath.FromSlash(name))
+
+	if dispatcher.overlay != nil {
+		contents, ok, err := dispatcher.overlay.Read(filepath.FromSlash(name))
+		if ok {
+			if err != nil {
+				return nil, relativeError(dispatcher.cwd, err)
+			}
+
+			return OverlayEntry{
+				Name: filepath.Base(path),
+				Size: int64(len(contents)),
+			}.DirEntry(), nil
+		}
+	}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) && dispatcher.overlay != nil &&
+		len(dispatcher.overlay.Entries(filepath.FromSlash(name))) > 0 {
+		return OverlayEntry{Name: filepath.Base(path), Dir: true}.DirEntry(), nil
+	}
+
+	if err != nil {
+		return nil, relativeError(dispatcher.cwd, err)
+	}
+
+	return fs.FileInfoToDirEntry(info), nil
+}
+
+// readDir returns entries of the slash-separated directory of the workspace
+// sorted by name, in dry-run mode files changed by the overlay replace the
+// files of the directory.
+func (dispatcher *Dispatcher) readDir(name string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(filepath.Join(dispatcher.cwd, filepath.FromSlash(name)))
+	if dispatcher.overlay == nil {
+		return entries, err
+	}
+
+	changes := dispatcher.overlay.Entries(filepath.FromSlash(name))
+	if err != nil && !(os.IsNotExist(err) && len(changes) > 0) {
+		return nil, err
+	}
+
+	changed := map[string]OverlayEntry{}
+	for _, change := range changes {
+		changed[change.Name] = change
+	}
+
+	result := []fs.DirEntry{}
+	for _, entry := range entries {
+		change, ok := changed[entry.Name()]
+		if !ok {
+			result = append(result, entry)
+			continue
+		}
+
+		delete(changed, entry.Name())
+
+		switch {
+		case change.Removed:
+		case change.Dir || entry.IsDir():
+			// files of the directory are merged when it's walked
+			result = append(result, entry)
+		default:
+			result = append(result, change.DirEntry())
+		}
+	}
+
+	for _, change := range changed {
+		if !change.Removed {
+			result = append(result, change.DirEntry())
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+
+	return result, nil
+}